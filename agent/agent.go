@@ -0,0 +1,109 @@
+// Package agent runs the metrics agent with collectors of your own.
+//
+// The agent binary is this package with only the built-in collectors. To
+// report more, build your own binary that registers collectors before it
+// runs the agent:
+//
+//	type queueCollector struct{ queue *Queue }
+//
+//	func (queueCollector) Name() string { return "queue" }
+//
+//	func (c queueCollector) Collect(context.Context) []agent.Metric {
+//		return []agent.Metric{
+//			agent.Gauge("QueueLength", float64(c.queue.Len())),
+//			agent.Counter("QueueProcessed", c.queue.Processed()),
+//		}
+//	}
+//
+//	func main() {
+//		agent.MustRegister(queueCollector{queue})
+//		agent.Main()
+//	}
+//
+// Every registered collector is polled on its own goroutine and can be
+// disabled or given its own poll interval through the agent config, under
+// its name.
+package agent
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/lambawebdev/metrics/internal/agent/collector"
+	"github.com/lambawebdev/metrics/internal/agent/config"
+	"github.com/lambawebdev/metrics/internal/agent/services/report"
+	"github.com/lambawebdev/metrics/internal/logger"
+	"github.com/lambawebdev/metrics/internal/models"
+	"go.uber.org/zap"
+)
+
+// Metric is one value reported by a collector.
+type Metric = models.Metrics
+
+// Labels are optional dimensions of a metric, such as a disk or a queue.
+type Labels = models.Labels
+
+// Collector gathers one group of metrics. Counters are reported as running
+// totals, the agent sends the deltas. Gauges are reported as their current
+// value.
+type Collector = collector.Collector
+
+// Starter is implemented by collectors that sample in the background. Start
+// is called once with the poll interval before the first Collect and must
+// not block; sampling stops when ctx is done.
+type Starter = collector.Starter
+
+// Register adds a collector. Names must be unique.
+func Register(c Collector) error {
+	return collector.Register(c)
+}
+
+// MustRegister is like Register but panics on a duplicate name.
+func MustRegister(c Collector) {
+	collector.MustRegister(c)
+}
+
+// Gauge returns a gauge metric with its current value.
+func Gauge(name string, value float64) Metric {
+	return Metric{ID: name, MType: "gauge", Value: &value}
+}
+
+// Counter returns a counter metric with its running total.
+func Counter(name string, total int64) Metric {
+	return Metric{ID: name, MType: "counter", Delta: &total}
+}
+
+// Main runs the agent configured by the command line and the environment
+// until SIGINT or SIGTERM. It exits the process if the configuration is
+// invalid.
+func Main() {
+	cfg, err := config.New(os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Config error: %+v\n", err)
+		os.Exit(2)
+	}
+
+	if err := logger.Initialize(cfg.LogLevel); err != nil {
+		panic(err)
+	}
+	defer logger.Log.Sync()
+
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Log.Error("Recovered from panic", zap.Any("panic", r))
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	report.Start(ctx, cfg, logger.Log)
+}
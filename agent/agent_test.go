@@ -0,0 +1,38 @@
+package agent_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/lambawebdev/metrics/agent"
+	"github.com/lambawebdev/metrics/internal/agent/collector"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type queueCollector struct{}
+
+func (queueCollector) Name() string {
+	return "queue"
+}
+
+func (queueCollector) Collect(_ context.Context) []agent.Metric {
+	return []agent.Metric{agent.Gauge("QueueLength", 3), agent.Counter("QueueProcessed", 42)}
+}
+
+func TestRegister(t *testing.T) {
+	require.NoError(t, agent.Register(queueCollector{}))
+	assert.Error(t, agent.Register(queueCollector{}))
+	assert.Panics(t, func() { agent.MustRegister(queueCollector{}) })
+
+	registered := collector.Registered()
+	custom := registered[len(registered)-1]
+	require.Equal(t, "queue", custom.Name())
+
+	metrics := custom.Collect(context.Background())
+	require.Len(t, metrics, 2)
+	assert.Equal(t, "gauge", metrics[0].MType)
+	assert.Equal(t, 3.0, *metrics[0].Value)
+	assert.Equal(t, "counter", metrics[1].MType)
+	assert.Equal(t, int64(42), *metrics[1].Delta)
+}
@@ -0,0 +1,395 @@
+// Package client pushes application metrics to the metrics server.
+//
+// A Client aggregates values locally and sends them in one gzip compressed
+// batch to /updates/ on every flush:
+//
+//	c := client.New(client.WithAddress("localhost:8080"), client.WithSecretKey(key))
+//	defer c.Close(context.Background())
+//
+//	c.Counter("OrdersCreated", 1)
+//	c.Gauge("QueueLength", float64(len(queue)))
+//	c.Histogram("CheckoutSeconds", nil).Observe(elapsed.Seconds())
+//
+// Between flushes counters are summed, gauges keep their last value and
+// histograms merge observations. A batch that failed to send is sent again,
+// with the same idempotency key so the server applies it once, before the
+// batch of the next flush. A batch the server rejects is dropped, as it
+// would be rejected again.
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/lambawebdev/metrics/internal/models"
+	"github.com/lambawebdev/metrics/internal/retry"
+)
+
+type Client struct {
+	address       string
+	secretKey     []byte
+	host          string
+	labels        models.Labels
+	flushInterval time.Duration
+	httpClient    *http.Client
+	onError       func(error)
+
+	mu         sync.Mutex
+	gauges     map[string]float64
+	counters   map[string]int64
+	histograms map[string]*Histogram
+
+	flushMu sync.Mutex
+	pending *pendingBatch
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// pendingBatch is a batch not sent yet, with the idempotency key it is sent
+// with every time.
+type pendingBatch struct {
+	key     string
+	metrics []models.Metrics
+}
+
+type Option func(*Client)
+
+// WithAddress sets the host:port of the server, localhost:8080 by default.
+func WithAddress(address string) Option {
+	return func(c *Client) {
+		c.address = address
+	}
+}
+
+// WithSecretKey signs every batch with an HMAC-SHA256 of the key.
+func WithSecretKey(key string) Option {
+	return func(c *Client) {
+		c.secretKey = []byte(key)
+	}
+}
+
+// WithHost sets the identity metrics are stored under, the hostname by
+// default.
+func WithHost(host string) Option {
+	return func(c *Client) {
+		c.host = host
+	}
+}
+
+// WithLabels adds labels to every metric of the client.
+func WithLabels(labels map[string]string) Option {
+	return func(c *Client) {
+		c.labels = labels
+	}
+}
+
+// WithFlushInterval sets how often metrics are sent in the background, 10s
+// by default. Zero disables background flushes, leaving them to Flush.
+func WithFlushInterval(interval time.Duration) Option {
+	return func(c *Client) {
+		c.flushInterval = interval
+	}
+}
+
+// WithHTTPClient replaces the HTTP client used to send batches.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithErrorHandler receives errors of background flushes, which are
+// otherwise dropped.
+func WithErrorHandler(onError func(error)) Option {
+	return func(c *Client) {
+		c.onError = onError
+	}
+}
+
+func New(opts ...Option) *Client {
+	c := &Client{
+		address:       "localhost:8080",
+		flushInterval: 10 * time.Second,
+		httpClient:    &http.Client{Timeout: 10 * time.Second},
+		onError:       func(error) {},
+		gauges:        make(map[string]float64),
+		counters:      make(map[string]int64),
+		histograms:    make(map[string]*Histogram),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+
+	if hostname, err := os.Hostname(); err == nil {
+		c.host = hostname
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.flushInterval > 0 {
+		go c.run()
+	} else {
+		close(c.done)
+	}
+
+	return c
+}
+
+// Gauge sets the current value of a gauge.
+func (c *Client) Gauge(name string, value float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gauges[name] = value
+}
+
+// Counter adds delta to a counter.
+func (c *Client) Counter(name string, delta int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.counters[name] += delta
+}
+
+// Histogram returns the histogram of the given name, creating it with the
+// given bucket upper bounds, or models.DefaultBuckets if nil. The bounds are
+// sorted, and NaN, infinite and repeated ones left out, as the server only
+// takes finite ascending bounds. Buckets of an existing histogram are not
+// changed.
+func (c *Client) Histogram(name string, buckets []float64) *Histogram {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if h, ok := c.histograms[name]; ok {
+		return h
+	}
+
+	if buckets == nil {
+		buckets = models.DefaultBuckets
+	}
+
+	h := &Histogram{buckets: bounds(buckets)}
+	c.histograms[name] = h
+
+	return h
+}
+
+// bounds returns the finite values of buckets, sorted and without repeats.
+func bounds(buckets []float64) []float64 {
+	var finite []float64
+	for _, b := range buckets {
+		if !math.IsNaN(b) && !math.IsInf(b, 0) {
+			finite = append(finite, b)
+		}
+	}
+
+	slices.Sort(finite)
+	return slices.Compact(finite)
+}
+
+// Flush sends the batch of a failed flush, if any, and then everything
+// aggregated since the previous flush.
+func (c *Client) Flush(ctx context.Context) error {
+	c.flushMu.Lock()
+	defer c.flushMu.Unlock()
+
+	if c.pending != nil {
+		if err := c.sendPending(ctx); err != nil {
+			return err
+		}
+	}
+
+	batch := c.batch(c.take())
+	if len(batch) == 0 {
+		return nil
+	}
+
+	key, err := newIdempotencyKey()
+	if err != nil {
+		return err
+	}
+
+	c.pending = &pendingBatch{key: key, metrics: batch}
+	return c.sendPending(ctx)
+}
+
+// sendPending sends the pending batch. It stays pending if sending it
+// failed, unless the server rejected it.
+func (c *Client) sendPending(ctx context.Context) error {
+	err := c.send(ctx, c.pending.key, c.pending.metrics)
+	if err == nil || rejected(err) {
+		c.pending = nil
+	}
+
+	return err
+}
+
+// rejected reports whether the server refused a batch, rather than failed
+// to take it.
+func rejected(err error) bool {
+	var httpErr *retry.HTTPError
+	return errors.As(err, &httpErr) && !retry.Retryable(err)
+}
+
+// newIdempotencyKey returns a random key identifying one batch.
+func newIdempotencyKey() (string, error) {
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(key), nil
+}
+
+// Close stops background flushes and flushes what is left.
+func (c *Client) Close(ctx context.Context) error {
+	select {
+	case <-c.stop:
+	default:
+		close(c.stop)
+	}
+	<-c.done
+
+	return c.Flush(ctx)
+}
+
+func (c *Client) run() {
+	defer close(c.done)
+
+	ticker := time.NewTicker(c.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			if err := c.Flush(context.Background()); err != nil {
+				c.onError(err)
+			}
+		}
+	}
+}
+
+func (c *Client) take() (map[string]float64, map[string]int64, map[string]*models.Histogram) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	gauges, counters := c.gauges, c.counters
+	c.gauges = make(map[string]float64)
+	c.counters = make(map[string]int64)
+
+	histograms := make(map[string]*models.Histogram)
+	for name, h := range c.histograms {
+		if observed := h.take(); observed != nil {
+			histograms[name] = observed
+		}
+	}
+
+	return gauges, counters, histograms
+}
+
+func (c *Client) batch(gauges map[string]float64, counters map[string]int64, histograms map[string]*models.Histogram) []models.Metrics {
+	var batch []models.Metrics
+
+	for name, value := range gauges {
+		batch = append(batch, models.Metrics{ID: name, MType: "gauge", Value: &value, Host: c.host, Labels: c.labels})
+	}
+
+	for name, delta := range counters {
+		batch = append(batch, models.Metrics{ID: name, MType: "counter", Delta: &delta, Host: c.host, Labels: c.labels})
+	}
+
+	for name, h := range histograms {
+		batch = append(batch, models.Metrics{ID: name, MType: "histogram", Histogram: h, Host: c.host, Labels: c.labels})
+	}
+
+	return batch
+}
+
+func (c *Client) send(ctx context.Context, key string, batch []models.Metrics) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	if _, err = zw.Write(body); err != nil {
+		return err
+	}
+	if err = zw.Close(); err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("http://%s/updates/", c.address)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, &compressed)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("Idempotency-Key", key)
+
+	if len(c.secretKey) > 0 {
+		mac := hmac.New(sha256.New, c.secretKey)
+		mac.Write(body)
+		req.Header.Set("HashSHA256", hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return retry.NewHTTPError(resp.StatusCode, resp.Status, resp.Header)
+	}
+
+	return nil
+}
+
+// Histogram counts observations into buckets until the next flush.
+type Histogram struct {
+	buckets []float64
+
+	mu       sync.Mutex
+	observed *models.Histogram
+}
+
+func (h *Histogram) Observe(value float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.observed == nil {
+		h.observed = models.NewHistogram(h.buckets)
+	}
+	h.observed.Observe(value)
+}
+
+func (h *Histogram) take() *models.Histogram {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	observed := h.observed
+	h.observed = nil
+
+	return observed
+}
@@ -0,0 +1,167 @@
+package client
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lambawebdev/metrics/internal/models"
+	"github.com/lambawebdev/metrics/internal/server/config"
+	"github.com/lambawebdev/metrics/internal/server/handlers"
+	"github.com/lambawebdev/metrics/internal/server/middleware"
+	"github.com/lambawebdev/metrics/internal/server/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientFlush(t *testing.T) {
+	s := new(storage.MemStorage)
+	mh := handlers.NewMetricHandler(s, config.Default())
+
+	srv := httptest.NewServer(middleware.GzipMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		mh.UpdateMetricBatch(w, r)
+	}))
+	defer srv.Close()
+
+	c := New(
+		WithAddress(strings.TrimPrefix(srv.URL, "http://")),
+		WithHost("checkout-1"),
+		WithLabels(map[string]string{"service": "checkout"}),
+		WithFlushInterval(0),
+	)
+
+	c.Counter("Orders", 2)
+	c.Counter("Orders", 3)
+	c.Gauge("QueueLength", 7)
+	c.Gauge("QueueLength", 4)
+	h := c.Histogram("CheckoutSeconds", []float64{0.1, 1})
+	h.Observe(0.05)
+	h.Observe(0.5)
+	require.NoError(t, c.Flush(context.Background()))
+
+	c.Counter("Orders", 1)
+	c.Histogram("CheckoutSeconds", nil).Observe(5)
+	require.NoError(t, c.Close(context.Background()))
+
+	labels := models.Labels{"service": "checkout"}
+
+	orders, found := s.GetMetric(context.Background(), "checkout-1", "Orders", "counter", labels)
+	require.True(t, found)
+	assert.Equal(t, int64(6), *orders.Delta)
+
+	queue, found := s.GetMetric(context.Background(), "checkout-1", "QueueLength", "gauge", labels)
+	require.True(t, found)
+	assert.Equal(t, float64(4), *queue.Value)
+
+	checkout, found := s.GetMetric(context.Background(), "checkout-1", "CheckoutSeconds", "histogram", labels)
+	require.True(t, found)
+	assert.Equal(t, []uint64{1, 1, 1}, checkout.Histogram.Counts)
+}
+
+func TestClientRetriesFailedBatch(t *testing.T) {
+	const key = "secret"
+
+	fail := true
+	var keys []string
+	var received [][]models.Metrics
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		assert.Equal(t, "gzip", r.Header.Get("Content-Encoding"))
+		zr, err := gzip.NewReader(r.Body)
+		require.NoError(t, err)
+		body, err := io.ReadAll(zr)
+		require.NoError(t, err)
+
+		mac := hmac.New(sha256.New, []byte(key))
+		mac.Write(body)
+		assert.Equal(t, hex.EncodeToString(mac.Sum(nil)), r.Header.Get("HashSHA256"))
+
+		var batch []models.Metrics
+		require.NoError(t, json.Unmarshal(body, &batch))
+		received = append(received, batch)
+	}))
+	defer srv.Close()
+
+	c := New(WithAddress(strings.TrimPrefix(srv.URL, "http://")), WithSecretKey(key), WithFlushInterval(0))
+
+	c.Counter("Orders", 2)
+	c.Gauge("QueueLength", 1)
+	assert.Error(t, c.Flush(context.Background()))
+
+	c.Counter("Orders", 3)
+	c.Gauge("QueueLength", 9)
+
+	fail = false
+	require.NoError(t, c.Flush(context.Background()))
+
+	// The failed batch is sent again as it was, then the new one.
+	require.Len(t, keys, 3)
+	assert.NotEmpty(t, keys[0])
+	assert.Equal(t, keys[0], keys[1])
+	assert.NotEqual(t, keys[1], keys[2])
+
+	var orders int64
+	var queue float64
+	for _, batch := range received {
+		for _, m := range batch {
+			switch m.ID {
+			case "Orders":
+				orders += *m.Delta
+			case "QueueLength":
+				queue = *m.Value
+			}
+		}
+	}
+	assert.Equal(t, int64(5), orders)
+	assert.Equal(t, float64(9), queue)
+}
+
+func TestClientDropsRejectedBatch(t *testing.T) {
+	var received int
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		received++
+		if received == 1 {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	c := New(WithAddress(strings.TrimPrefix(srv.URL, "http://")), WithFlushInterval(0))
+
+	c.Gauge("Queue-Length", 1)
+	assert.Error(t, c.Flush(context.Background()))
+
+	// The rejected batch is not sent again, later ones go through.
+	c.Gauge("QueueLength", 1)
+	require.NoError(t, c.Flush(context.Background()))
+	assert.Equal(t, 2, received)
+}
+
+func TestClientHistogramBuckets(t *testing.T) {
+	c := New(WithFlushInterval(0))
+
+	h := c.Histogram("CheckoutSeconds", []float64{1, math.Inf(1), 0.1, math.NaN(), 1, 0.5})
+	h.Observe(0.3)
+	h.Observe(2)
+
+	observed := h.take()
+	assert.Equal(t, []float64{0.1, 0.5, 1}, observed.Buckets)
+	assert.Equal(t, []uint64{0, 1, 0, 1}, observed.Counts)
+	assert.NoError(t, observed.Validate())
+}
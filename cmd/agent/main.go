@@ -1,20 +1,7 @@
 package main
 
-import (
-	"fmt"
-
-	"github.com/lambawebdev/metrics/internal/agent/config"
-	"github.com/lambawebdev/metrics/internal/agent/services/report"
-)
+import "github.com/lambawebdev/metrics/agent"
 
 func main() {
-	config.ParseFlags()
-
-	defer func() {
-		if r := recover(); r != nil {
-			fmt.Println("Recovered from panic:", r)
-		}
-	}()
-
-	report.Start()
+	agent.Main()
 }
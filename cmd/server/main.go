@@ -1,100 +1,199 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"database/sql"
 
 	"github.com/go-chi/chi/v5"
 	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/lambawebdev/metrics/internal/logger"
+	"github.com/lambawebdev/metrics/internal/server/accesslog"
 	"github.com/lambawebdev/metrics/internal/server/config"
 	"github.com/lambawebdev/metrics/internal/server/handlers"
-	"github.com/lambawebdev/metrics/internal/server/logger"
+	"github.com/lambawebdev/metrics/internal/server/health"
 	"github.com/lambawebdev/metrics/internal/server/middleware"
+	"github.com/lambawebdev/metrics/internal/server/selfmetrics"
 	"github.com/lambawebdev/metrics/internal/server/storage"
+	"github.com/lambawebdev/metrics/internal/server/tracing"
 	"go.uber.org/zap"
 )
 
+// probeTimeout bounds the checks of the health probes.
+const probeTimeout = 2 * time.Second
+
 func main() {
-	config.ParseFlags()
+	cfg, err := config.New(os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Config error: %+v\n", err)
+		os.Exit(2)
+	}
+
+	if err := logger.Initialize(cfg.LogLevel); err != nil {
+		panic(err)
+	}
+	accesslog.SetSampling(cfg.AccessLogSample)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.TraceEndpoint)
+	if err != nil {
+		panic(err)
+	}
+	defer shutdownTracing(context.Background())
 
-	db, err := sql.Open("pgx", config.GetDatabaseDsn())
+	db, err := sql.Open("pgx", cfg.DatabaseDSN)
 	if err != nil {
 		panic(err)
 	}
 	defer db.Close()
 
 	r := chi.NewRouter()
+	r.Use(tracing.Middleware)
+	r.Use(selfmetrics.Middleware(selfmetrics.Default))
 
-	s, err := storage.GetStorageFactory(db)
+	s, err := storage.GetStorageFactory(db, cfg, logger.Log.Named("storage"))
 	if err != nil {
 		panic(err)
 	}
+	s = storage.Instrument(s, selfmetrics.Default)
 
-	go storage.StartToWrite(s, config.GetStoreIntervalSeconds())
+	// Snapshots are of the metrics in memory, a database keeps them itself.
+	var storeInterval chan uint64
+	if cfg.DatabaseDSN == "" {
+		storeInterval = make(chan uint64, 1)
+		go storage.StartToWrite(s, cfg.StoreIntervalSeconds, cfg.FileStoragePath, storeInterval, logger.Log.Named("snapshot"))
+	}
 
-	if databaseDsn := os.Getenv("DATABASE_DSN"); databaseDsn != "" {
-		createMetricsTable(db)
+	if cfg.DatabaseDSN != "" {
+		if err := storage.Migrate(db); err != nil {
+			panic(err)
+		}
 	}
 
-	mh := handlers.NewMetricHandler(s)
+	mh := handlers.NewMetricHandler(s, cfg)
+
+	reloader := config.NewReloader(cfg, func() (*config.Config, error) {
+		return config.New(os.Args[1:], os.LookupEnv)
+	})
+	reloader.OnReload(func(cfg *config.Config) {
+		if err := logger.SetLevel(cfg.LogLevel); err != nil {
+			logger.Log.Error("Log level not changed", zap.Error(err))
+		}
+		accesslog.SetSampling(cfg.AccessLogSample)
+		mh.SetConfig(cfg)
+		if storeInterval != nil {
+			storage.ResetInterval(storeInterval, cfg.StoreIntervalSeconds)
+		}
+	})
+	go reloadOnHangup(reloader)
+
+	ah := handlers.NewAdminHandler(reloader, s)
+	adminToken := func() string { return reloader.Current().AdminToken }
+
+	r.Get("/ping", accesslog.WithLoggingMiddleware(middleware.GzipMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		mh.Ping(w, r)
+	})))
+
+	r.Get("/healthz", accesslog.WithLoggingMiddleware(health.NewProbe(probeTimeout).Handler()))
+	r.Get("/readyz", accesslog.WithLoggingMiddleware(readiness(s, db, reloader).Handler()))
 
-	r.Get("/ping", logger.WithLoggingMiddleware(middleware.GzipMiddleware(func(w http.ResponseWriter, _r *http.Request) {
-		mh.Ping(w, db)
+	r.Get("/", accesslog.WithLoggingMiddleware(middleware.GzipMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		mh.GetMetrics(w, r)
 	})))
 
-	r.Get("/", logger.WithLoggingMiddleware(middleware.GzipMiddleware(func(w http.ResponseWriter, _ *http.Request) {
-		mh.GetMetrics(w)
+	r.Get("/metrics", accesslog.WithLoggingMiddleware(middleware.GzipMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		mh.GetMetricsPrometheus(w, r)
 	})))
 
-	r.Post("/value/", logger.WithLoggingMiddleware(middleware.GzipMiddleware(func(w http.ResponseWriter, r *http.Request) {
+	r.Get("/debug/metrics", accesslog.WithLoggingMiddleware(middleware.GzipMiddleware(selfmetrics.Handler(selfmetrics.Default))))
+
+	r.Post("/value/", accesslog.WithLoggingMiddleware(middleware.GzipMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		mh.GetMetricV2(w, r)
 	})))
 
-	r.Get("/value/{type}/{name}", logger.WithLoggingMiddleware(middleware.GzipMiddleware(func(w http.ResponseWriter, r *http.Request) {
+	r.Get("/value/{type}/{name}", accesslog.WithLoggingMiddleware(middleware.GzipMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		mh.GetMetric(w, r)
 	})))
 
-	r.Post("/update/", logger.WithLoggingMiddleware(middleware.GzipMiddleware(func(w http.ResponseWriter, r *http.Request) {
+	r.Post("/update/", accesslog.WithLoggingMiddleware(middleware.GzipMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		mh.UpdateMetricV2(w, r)
 	})))
 
-	r.Post("/update/{type}/{name}/{value}", logger.WithLoggingMiddleware(middleware.GzipMiddleware(func(w http.ResponseWriter, r *http.Request) {
+	r.Post("/update/{type}/{name}/{value}", accesslog.WithLoggingMiddleware(middleware.GzipMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		mh.UpdateMetric(w, r)
 	})))
 
-	r.Post("/updates/", logger.WithLoggingMiddleware(middleware.GzipMiddleware(func(w http.ResponseWriter, r *http.Request) {
+	r.Post("/updates/", accesslog.WithLoggingMiddleware(middleware.GzipMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		mh.UpdateMetricBatch(w, r)
 	})))
 
-	err = run(r)
+	r.Post("/admin/reload", accesslog.WithLoggingMiddleware(middleware.AdminAuthMiddleware(adminToken, func(w http.ResponseWriter, r *http.Request) {
+		ah.Reload(w, r)
+	})))
+
+	r.Get("/admin/export", accesslog.WithLoggingMiddleware(middleware.AdminAuthMiddleware(adminToken, middleware.GzipMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		ah.Export(w, r)
+	}))))
+
+	r.Post("/admin/import", accesslog.WithLoggingMiddleware(middleware.AdminAuthMiddleware(adminToken, middleware.GzipMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		ah.Import(w, r)
+	}))))
+
+	err = run(r, cfg)
 	if err != nil {
 		panic(err)
 	}
 }
 
-func run(handler *chi.Mux) error {
-	if err := logger.Initialize("info"); err != nil {
-		return err
+// readiness checks that the storage can be reached and, with a database,
+// that its schema is current or, in memory, that the snapshots are not
+// falling behind. A snapshot may be late by one store interval before the
+// server stops being ready.
+func readiness(s storage.MetricStorage, db *sql.DB, reloader *config.Reloader) *health.Probe {
+	probe := health.NewProbe(probeTimeout)
+
+	probe.Add("storage", s.Ping)
+
+	if reloader.Current().DatabaseDSN != "" {
+		probe.Add("migrations", func(ctx context.Context) error {
+			return storage.CheckSchemaVersion(ctx, db)
+		})
+	} else {
+		probe.Add("snapshot", health.MaxAge(storage.LastSnapshot, func() time.Duration {
+			return 2 * time.Duration(reloader.Current().StoreIntervalSeconds) * time.Second
+		}))
 	}
 
-	logger.Log.Info("Starting server", zap.String("address", config.GetFlagRunAddr()))
+	return probe
+}
+
+func run(handler *chi.Mux, cfg *config.Config) error {
+	logger.Log.Info("Starting server", zap.String("address", cfg.Address))
 
-	return http.ListenAndServe(config.GetFlagRunAddr(), handler)
+	return http.ListenAndServe(cfg.Address, handler)
 }
 
-func createMetricsTable(db *sql.DB) {
-	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS metrics (
-	    id INT GENERATED ALWAYS AS IDENTITY PRIMARY KEY, 
-		name VARCHAR(30) UNIQUE,
-		type VARCHAR(30),
-		delta BIGINT,
-	    value double precision
-		);
-	`)
+// reloadOnHangup reloads the configuration on every SIGHUP.
+func reloadOnHangup(reloader *config.Reloader) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
 
-	if err != nil {
-		panic(err)
+	for range hangup {
+		if err := reloader.Reload(); err != nil {
+			logger.Log.Error("Config reload rejected", zap.Error(err))
+			continue
+		}
+
+		logger.Log.Info("Config reloaded")
 	}
 }
@@ -0,0 +1,257 @@
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lambawebdev/metrics/internal/models"
+)
+
+// CgroupCollector reports memory, CPU, IO and pid usage of a cgroup v2
+// against its limits. Limits set to "max" are not reported. CPU usage in
+// cores is averaged since the previous poll, so the first poll omits it.
+type CgroupCollector struct {
+	dir string
+
+	mu       sync.Mutex
+	usage    int64
+	polledAt time.Time
+}
+
+// NewCgroupCollector watches the cgroup directory dir, such as
+// /sys/fs/cgroup/system.slice/agent.service.
+func NewCgroupCollector(dir string) *CgroupCollector {
+	return &CgroupCollector{dir: dir}
+}
+
+// CgroupDir resolves a cgroup v2 path, relative to /sys/fs/cgroup or
+// absolute, to its directory. An empty path resolves to the cgroup of the
+// agent itself.
+func CgroupDir(path string) (string, error) {
+	if path == "" {
+		data, err := os.ReadFile("/proc/self/cgroup")
+		if err != nil {
+			return "", err
+		}
+
+		if path, err = parseProcCgroup(data); err != nil {
+			return "", err
+		}
+	}
+
+	if strings.HasPrefix(path, cgroupRoot) {
+		return path, nil
+	}
+
+	return filepath.Join(cgroupRoot, path), nil
+}
+
+// parseProcCgroup returns the unified hierarchy path from /proc/self/cgroup,
+// which has a "0::/path" line on cgroup v2 hosts.
+func parseProcCgroup(data []byte) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if path, ok := strings.CutPrefix(scanner.Text(), "0::"); ok {
+			return path, nil
+		}
+	}
+
+	return "", errors.New("no cgroup v2 hierarchy found")
+}
+
+func (c *CgroupCollector) Name() string {
+	return "cgroup"
+}
+
+func (c *CgroupCollector) Collect(_ context.Context) []models.Metrics {
+	var metrics []models.Metrics
+
+	metrics = append(metrics, c.memoryMetrics()...)
+	metrics = append(metrics, c.cpuMetrics()...)
+	metrics = append(metrics, c.ioMetrics()...)
+	metrics = append(metrics, c.pidsMetrics()...)
+
+	return metrics
+}
+
+func (c *CgroupCollector) memoryMetrics() []models.Metrics {
+	var metrics []models.Metrics
+
+	current, err := c.readInt("memory.current")
+	if err != nil {
+		return nil
+	}
+	metrics = append(metrics, gauge("CgroupMemoryCurrentBytes", float64(current)))
+
+	if limit, err := c.readInt("memory.max"); err == nil && limit > 0 {
+		metrics = append(metrics,
+			gauge("CgroupMemoryLimitBytes", float64(limit)),
+			gauge("CgroupMemoryUsedPercent", float64(current)/float64(limit)*100),
+		)
+	}
+
+	return metrics
+}
+
+func (c *CgroupCollector) cpuMetrics() []models.Metrics {
+	stat, err := c.readKeyValues("cpu.stat")
+	if err != nil {
+		return nil
+	}
+
+	metrics := []models.Metrics{
+		counter("CgroupCPUUsageMicroseconds", stat["usage_usec"]),
+		counter("CgroupCPUPeriods", stat["nr_periods"]),
+		counter("CgroupCPUThrottledPeriods", stat["nr_throttled"]),
+		counter("CgroupCPUThrottledMicroseconds", stat["throttled_usec"]),
+	}
+
+	c.mu.Lock()
+	now := time.Now()
+	previous, elapsed := c.usage, now.Sub(c.polledAt)
+	first := c.polledAt.IsZero()
+	c.usage, c.polledAt = stat["usage_usec"], now
+	c.mu.Unlock()
+
+	var usageCores float64
+	measured := !first && elapsed > 0 && stat["usage_usec"] >= previous
+	if measured {
+		usageCores = float64(stat["usage_usec"]-previous) / float64(elapsed.Microseconds())
+		metrics = append(metrics, gauge("CgroupCPUUsageCores", usageCores))
+	}
+
+	if limitCores, err := c.readCPULimit(); err == nil {
+		metrics = append(metrics, gauge("CgroupCPULimitCores", limitCores))
+
+		// A zero quota would make the percentage infinite, which the
+		// server rejects along with the rest of the batch.
+		if measured && limitCores > 0 {
+			metrics = append(metrics, gauge("CgroupCPUUsedPercent", usageCores/limitCores*100))
+		}
+	}
+
+	return metrics
+}
+
+// readCPULimit parses cpu.max, formatted as "quota period" with a quota of
+// "max" for no limit.
+func (c *CgroupCollector) readCPULimit() (float64, error) {
+	data, err := os.ReadFile(filepath.Join(c.dir, "cpu.max"))
+	if err != nil {
+		return 0, err
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) != 2 || fields[0] == "max" {
+		return 0, errors.New("no cpu limit")
+	}
+
+	quota, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, err
+	}
+
+	period, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil || period == 0 {
+		return 0, errors.New("invalid cpu period")
+	}
+
+	return quota / period, nil
+}
+
+// ioMetrics parses io.stat lines like "8:0 rbytes=1 wbytes=2 rios=3 wios=4".
+func (c *CgroupCollector) ioMetrics() []models.Metrics {
+	data, err := os.ReadFile(filepath.Join(c.dir, "io.stat"))
+	if err != nil {
+		return nil
+	}
+
+	names := map[string]string{
+		"rbytes": "CgroupIOReadBytes",
+		"wbytes": "CgroupIOWriteBytes",
+		"rios":   "CgroupIOReads",
+		"wios":   "CgroupIOWrites",
+	}
+
+	var metrics []models.Metrics
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		labels := models.Labels{"device": fields[0]}
+		for _, field := range fields[1:] {
+			key, value, ok := strings.Cut(field, "=")
+			name, known := names[key]
+			if !ok || !known {
+				continue
+			}
+
+			if v, err := strconv.ParseInt(value, 10, 64); err == nil {
+				metrics = append(metrics, labeled(counter(name, v), labels))
+			}
+		}
+	}
+
+	return metrics
+}
+
+func (c *CgroupCollector) pidsMetrics() []models.Metrics {
+	var metrics []models.Metrics
+
+	if current, err := c.readInt("pids.current"); err == nil {
+		metrics = append(metrics, gauge("CgroupPidsCurrent", float64(current)))
+	}
+
+	if limit, err := c.readInt("pids.max"); err == nil && limit > 0 {
+		metrics = append(metrics, gauge("CgroupPidsLimit", float64(limit)))
+	}
+
+	return metrics
+}
+
+// readInt reads a single value file, returning 0 for "max".
+func (c *CgroupCollector) readInt(name string) (int64, error) {
+	data, err := os.ReadFile(filepath.Join(c.dir, name))
+	if err != nil {
+		return 0, err
+	}
+
+	value := strings.TrimSpace(string(data))
+	if value == "max" {
+		return 0, nil
+	}
+
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// readKeyValues reads flat keyed files like cpu.stat.
+func (c *CgroupCollector) readKeyValues(name string) (map[string]int64, error) {
+	data, err := os.ReadFile(filepath.Join(c.dir, name))
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]int64)
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+
+		if v, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+			values[fields[0]] = v
+		}
+	}
+
+	return values, nil
+}
@@ -0,0 +1,131 @@
+package collector
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCgroupCollectorLimited(t *testing.T) {
+	byID := metricsByID(NewCgroupCollector("testdata/cgroup/limited").Collect(context.Background()))
+
+	gauges := map[string]float64{
+		"CgroupMemoryCurrentBytes": 268435456,
+		"CgroupMemoryLimitBytes":   536870912,
+		"CgroupMemoryUsedPercent":  50,
+		"CgroupCPULimitCores":      0.5,
+		"CgroupPidsCurrent":        12,
+		"CgroupPidsLimit":          100,
+	}
+	for id, want := range gauges {
+		require.Len(t, byID[id], 1, id)
+		assert.Equal(t, "gauge", byID[id][0].MType, id)
+		assert.Equal(t, want, *byID[id][0].Value, id)
+	}
+
+	counters := map[string]int64{
+		"CgroupCPUUsageMicroseconds":     5000000,
+		"CgroupCPUPeriods":               100,
+		"CgroupCPUThrottledPeriods":      25,
+		"CgroupCPUThrottledMicroseconds": 750000,
+	}
+	for id, want := range counters {
+		require.Len(t, byID[id], 1, id)
+		assert.Equal(t, "counter", byID[id][0].MType, id)
+		assert.Equal(t, want, *byID[id][0].Delta, id)
+	}
+
+	assert.Empty(t, byID["CgroupCPUUsageCores"])
+
+	require.Len(t, byID["CgroupIOReadBytes"], 2)
+	for _, m := range byID["CgroupIOWriteBytes"] {
+		switch m.Labels["device"] {
+		case "8:0":
+			assert.Equal(t, int64(8192), *m.Delta)
+		case "253:1":
+			assert.Equal(t, int64(200), *m.Delta)
+		default:
+			t.Errorf("unexpected device %q", m.Labels["device"])
+		}
+	}
+}
+
+func TestCgroupCollectorUnlimited(t *testing.T) {
+	byID := metricsByID(NewCgroupCollector("testdata/cgroup/unlimited").Collect(context.Background()))
+
+	assert.Equal(t, float64(1048576), *byID["CgroupMemoryCurrentBytes"][0].Value)
+	assert.Equal(t, float64(3), *byID["CgroupPidsCurrent"][0].Value)
+
+	for _, id := range []string{"CgroupMemoryLimitBytes", "CgroupMemoryUsedPercent", "CgroupCPULimitCores", "CgroupPidsLimit", "CgroupIOReadBytes"} {
+		assert.Empty(t, byID[id], id)
+	}
+}
+
+func TestCgroupCollectorCPUUsage(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"cpu.stat", "cpu.max", "memory.current"} {
+		data, err := os.ReadFile(filepath.Join("testdata/cgroup/limited", name))
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), data, 0644))
+	}
+
+	c := NewCgroupCollector(dir)
+	c.Collect(context.Background())
+
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "cpu.stat"), []byte("usage_usec 5010000\nnr_periods 101\nnr_throttled 25\nthrottled_usec 750000\n"), 0644))
+
+	byID := metricsByID(c.Collect(context.Background()))
+	require.Len(t, byID["CgroupCPUUsageCores"], 1)
+	require.Len(t, byID["CgroupCPUUsedPercent"], 1)
+
+	usage := *byID["CgroupCPUUsageCores"][0].Value
+	assert.Greater(t, usage, float64(0))
+	assert.LessOrEqual(t, usage, 0.5)
+	assert.InDelta(t, usage/0.5*100, *byID["CgroupCPUUsedPercent"][0].Value, 1e-9)
+}
+
+func TestParseProcCgroup(t *testing.T) {
+	path, err := parseProcCgroup([]byte("0::/system.slice/agent.service\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "/system.slice/agent.service", path)
+
+	_, err = parseProcCgroup([]byte("12:memory:/docker/abc\n11:cpu:/docker/abc\n"))
+	assert.Error(t, err)
+
+	dir, err := CgroupDir("system.slice/agent.service")
+	require.NoError(t, err)
+	assert.Equal(t, "/sys/fs/cgroup/system.slice/agent.service", dir)
+}
+
+func TestCgroupCollectorCPUUsedPercentSkipped(t *testing.T) {
+	tests := []struct {
+		name   string
+		cpuMax string
+		stat   string
+	}{
+		{name: "Test usage went backwards", cpuMax: "50000 100000\n", stat: "usage_usec 1000\n"},
+		{name: "Test zero quota", cpuMax: "0 100000\n", stat: "usage_usec 5010000\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "cpu.stat"), []byte("usage_usec 5000000\n"), 0644))
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "cpu.max"), []byte(test.cpuMax), 0644))
+
+			c := NewCgroupCollector(dir)
+			c.Collect(context.Background())
+
+			time.Sleep(20 * time.Millisecond)
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "cpu.stat"), []byte(test.stat), 0644))
+
+			byID := metricsByID(c.Collect(context.Background()))
+			assert.Empty(t, byID["CgroupCPUUsedPercent"])
+		})
+	}
+}
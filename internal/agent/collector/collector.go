@@ -0,0 +1,85 @@
+// Package collector defines the sources of metrics polled by the agent.
+//
+// Built-in collectors register themselves on init. Custom collectors are
+// registered from Go code through package agent, before agent.Main:
+//
+//	agent.MustRegister(myCollector{})
+//
+// Every registered collector is polled on its own goroutine and can be
+// disabled or given its own poll interval through the agent config.
+//
+// Metric names are CamelCase, subject first and unit last, for example
+// FilesystemUsedBytes or DiskReadBytesPerSecond. Per-device dimensions such
+// as a mount point or a network interface are labels, not part of the name.
+package collector
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lambawebdev/metrics/internal/models"
+)
+
+// Collector gathers one group of metrics. Counters are reported as running
+// totals, gauges as their current value.
+type Collector interface {
+	Name() string
+	Collect(ctx context.Context) []models.Metrics
+}
+
+// Starter is implemented by collectors that sample in the background. Start
+// is called once with the poll interval before the first Collect and must
+// not block; sampling stops when ctx is done.
+type Starter interface {
+	Start(ctx context.Context, interval time.Duration)
+}
+
+var registry struct {
+	mu         sync.Mutex
+	collectors []Collector
+}
+
+// Register adds a collector. Names must be unique.
+func Register(c Collector) error {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	for _, registered := range registry.collectors {
+		if registered.Name() == c.Name() {
+			return fmt.Errorf("collector %q is already registered", c.Name())
+		}
+	}
+
+	registry.collectors = append(registry.collectors, c)
+	return nil
+}
+
+// MustRegister is like Register but panics on a duplicate name.
+func MustRegister(c Collector) {
+	if err := Register(c); err != nil {
+		panic(err)
+	}
+}
+
+// Registered returns the collectors in registration order.
+func Registered() []Collector {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	return append([]Collector(nil), registry.collectors...)
+}
+
+func gauge(id string, value float64) models.Metrics {
+	return models.Metrics{ID: id, MType: "gauge", Value: &value}
+}
+
+func counter(id string, delta int64) models.Metrics {
+	return models.Metrics{ID: id, MType: "counter", Delta: &delta}
+}
+
+func labeled(m models.Metrics, labels models.Labels) models.Metrics {
+	m.Labels = labels
+	return m
+}
@@ -0,0 +1,112 @@
+package collector
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lambawebdev/metrics/internal/models"
+	"github.com/shirou/gopsutil/v4/cpu"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type staticCollector struct {
+	name string
+}
+
+func (c staticCollector) Name() string {
+	return c.name
+}
+
+func (c staticCollector) Collect(_ context.Context) []models.Metrics {
+	return []models.Metrics{gauge("Static", 1)}
+}
+
+func TestRegister(t *testing.T) {
+	require.NoError(t, Register(staticCollector{name: "static"}))
+	assert.Error(t, Register(staticCollector{name: "static"}))
+	assert.Error(t, Register(staticCollector{name: "runtime"}))
+
+	var names []string
+	for _, c := range Registered() {
+		names = append(names, c.Name())
+	}
+	assert.Contains(t, names, "runtime")
+	assert.Contains(t, names, "memory")
+	assert.Equal(t, "static", names[len(names)-1])
+}
+
+func TestShortenMountPath(t *testing.T) {
+	volumes := "/var/lib/kubelet/pods/0f6a4b8e-2d1c-4c8e-9b7a-5e3f2a1d0c9b/volumes/kubernetes.io~csi/"
+	long := volumes + strings.Repeat("pvc-", 20) + "a"
+	other := volumes + strings.Repeat("pvc-", 20) + "b"
+
+	tests := []struct {
+		name string
+		path string
+	}{
+		{name: "long path", path: long},
+		{name: "multibyte at the cut", path: strings.Repeat("é", 100)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			short := shorten(tt.path)
+			assert.LessOrEqual(t, len(short), maxLabelValueLength)
+			assert.True(t, utf8.ValidString(short))
+			assert.Equal(t, short, shorten(tt.path), "the same path keeps its label")
+		})
+	}
+
+	assert.Equal(t, "/var/lib/docker", shorten("/var/lib/docker"))
+	assert.NotEqual(t, shorten(long), shorten(other))
+}
+
+func TestRuntimeCollector(t *testing.T) {
+	c := &RuntimeCollector{}
+
+	var pollCount int64
+	for i := 0; i < 3; i++ {
+		for _, m := range c.Collect(context.Background()) {
+			if m.ID == "PollCount" {
+				pollCount = *m.Delta
+				continue
+			}
+
+			require.Equal(t, "gauge", m.MType, m.ID)
+			require.NotNil(t, m.Value, m.ID)
+		}
+	}
+
+	assert.Equal(t, int64(3), pollCount)
+}
+
+func TestCPUCollector(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := &CPUCollector{}
+	assert.Empty(t, c.Collect(ctx))
+
+	c.Start(ctx, 50*time.Millisecond)
+
+	require.Eventually(t, func() bool {
+		return len(c.Collect(ctx)) > 0
+	}, 2*time.Second, 10*time.Millisecond)
+
+	cores, err := cpu.Counts(true)
+	require.NoError(t, err)
+
+	metrics := c.Collect(ctx)
+	assert.Len(t, metrics, cores)
+	assert.Equal(t, "CPUutilization1", metrics[0].ID)
+
+	for _, m := range metrics {
+		assert.Equal(t, "gauge", m.MType)
+		assert.GreaterOrEqual(t, *m.Value, float64(0))
+		assert.LessOrEqual(t, *m.Value, float64(100))
+	}
+}
@@ -0,0 +1,63 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lambawebdev/metrics/internal/models"
+	"github.com/shirou/gopsutil/v4/cpu"
+)
+
+func init() {
+	MustRegister(&CPUCollector{})
+}
+
+// CPUCollector reports the utilization of every logical core in percent as
+// CPUutilization1..N. Utilization is measured over a whole poll interval on
+// a background goroutine, so Collect returns the latest sample at once.
+type CPUCollector struct {
+	mu       sync.Mutex
+	percents []float64
+}
+
+func (c *CPUCollector) Name() string {
+	return "cpu"
+}
+
+func (c *CPUCollector) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		for {
+			percents, err := cpu.PercentWithContext(ctx, interval, true)
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(interval):
+				}
+				continue
+			}
+
+			c.mu.Lock()
+			c.percents = percents
+			c.mu.Unlock()
+		}
+	}()
+}
+
+func (c *CPUCollector) Collect(_ context.Context) []models.Metrics {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	metrics := make([]models.Metrics, 0, len(c.percents))
+	for i, percent := range c.percents {
+		metrics = append(metrics, gauge(fmt.Sprintf("CPUutilization%d", i+1), percent))
+	}
+
+	return metrics
+}
@@ -0,0 +1,63 @@
+package collector
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/lambawebdev/metrics/internal/models"
+	"github.com/shirou/gopsutil/v4/disk"
+)
+
+func init() {
+	MustRegister(&DiskIOCollector{})
+}
+
+// DiskIOCollector reports read and write rates of every block device,
+// labeled with device. Rates are averaged over the time since the previous
+// poll, so the first poll reports nothing.
+type DiskIOCollector struct {
+	mu       sync.Mutex
+	previous map[string]disk.IOCountersStat
+	polledAt time.Time
+}
+
+func (c *DiskIOCollector) Name() string {
+	return "diskio"
+}
+
+func (c *DiskIOCollector) Collect(ctx context.Context) []models.Metrics {
+	counters, err := disk.IOCountersWithContext(ctx)
+	if err != nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	previous, elapsed := c.previous, now.Sub(c.polledAt).Seconds()
+	c.previous, c.polledAt = counters, now
+
+	if previous == nil || elapsed <= 0 {
+		return nil
+	}
+
+	var metrics []models.Metrics
+	for name, current := range counters {
+		last, ok := previous[name]
+		if !ok || current.ReadBytes < last.ReadBytes || current.WriteBytes < last.WriteBytes {
+			continue
+		}
+
+		labels := models.Labels{"device": name}
+		metrics = append(metrics,
+			labeled(gauge("DiskReadBytesPerSecond", float64(current.ReadBytes-last.ReadBytes)/elapsed), labels),
+			labeled(gauge("DiskWriteBytesPerSecond", float64(current.WriteBytes-last.WriteBytes)/elapsed), labels),
+			labeled(gauge("DiskReadsPerSecond", float64(current.ReadCount-last.ReadCount)/elapsed), labels),
+			labeled(gauge("DiskWritesPerSecond", float64(current.WriteCount-last.WriteCount)/elapsed), labels),
+		)
+	}
+
+	return metrics
+}
@@ -0,0 +1,74 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"unicode/utf8"
+
+	"github.com/lambawebdev/metrics/internal/models"
+	"github.com/shirou/gopsutil/v4/disk"
+)
+
+// maxLabelValueLength is the longest label value the server accepts. One
+// longer value gets the whole batch rejected.
+const maxLabelValueLength = 128
+
+func init() {
+	MustRegister(FilesystemCollector{})
+}
+
+// FilesystemCollector reports space and inode usage of every mounted
+// physical filesystem, labeled with mount, device and fstype.
+type FilesystemCollector struct{}
+
+func (FilesystemCollector) Name() string {
+	return "filesystem"
+}
+
+func (FilesystemCollector) Collect(ctx context.Context) []models.Metrics {
+	partitions, err := disk.PartitionsWithContext(ctx, false)
+	if err != nil {
+		return nil
+	}
+
+	var metrics []models.Metrics
+	for _, p := range partitions {
+		usage, err := disk.UsageWithContext(ctx, p.Mountpoint)
+		if err != nil {
+			continue
+		}
+
+		labels := models.Labels{"mount": shorten(p.Mountpoint), "device": shorten(p.Device), "fstype": p.Fstype}
+		metrics = append(metrics,
+			labeled(gauge("FilesystemTotalBytes", float64(usage.Total)), labels),
+			labeled(gauge("FilesystemUsedBytes", float64(usage.Used)), labels),
+			labeled(gauge("FilesystemFreeBytes", float64(usage.Free)), labels),
+			labeled(gauge("FilesystemUsedPercent", usage.UsedPercent), labels),
+			labeled(gauge("FilesystemInodesTotal", float64(usage.InodesTotal)), labels),
+			labeled(gauge("FilesystemInodesUsed", float64(usage.InodesUsed)), labels),
+		)
+	}
+
+	return metrics
+}
+
+// shorten cuts a path longer than the server accepts as a label value, such
+// as the volume path of a container, and ends it with a hash of the full
+// path so that two long paths with the same prefix stay apart.
+func shorten(path string) string {
+	if len(path) <= maxLabelValueLength {
+		return path
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(path))
+	suffix := fmt.Sprintf("~%08x", h.Sum32())
+
+	cut := maxLabelValueLength - len(suffix)
+	for cut > 0 && !utf8.RuneStart(path[cut]) {
+		cut--
+	}
+
+	return path[:cut] + suffix
+}
@@ -0,0 +1,45 @@
+package collector
+
+import (
+	"context"
+
+	"github.com/lambawebdev/metrics/internal/models"
+	"github.com/shirou/gopsutil/v4/host"
+	"github.com/shirou/gopsutil/v4/load"
+)
+
+func init() {
+	MustRegister(HostCollector{})
+}
+
+// HostCollector reports the uptime of the host and the number of processes
+// and threads running on it.
+type HostCollector struct{}
+
+func (HostCollector) Name() string {
+	return "host"
+}
+
+func (HostCollector) Collect(ctx context.Context) []models.Metrics {
+	var metrics []models.Metrics
+
+	if uptime, err := host.UptimeWithContext(ctx); err == nil {
+		metrics = append(metrics, gauge("UptimeSeconds", float64(uptime)))
+	}
+
+	if misc, err := load.MiscWithContext(ctx); err == nil {
+		metrics = append(metrics,
+			gauge("ProcessCount", float64(misc.ProcsTotal)),
+			gauge("ProcessesRunning", float64(misc.ProcsRunning)),
+			gauge("ProcessesBlocked", float64(misc.ProcsBlocked)),
+			counter("ProcessesCreated", int64(misc.ProcsCreated)),
+			counter("ContextSwitches", int64(misc.Ctxt)),
+		)
+	}
+
+	if threads, err := threadCount(); err == nil {
+		metrics = append(metrics, gauge("ThreadCount", float64(threads)))
+	}
+
+	return metrics
+}
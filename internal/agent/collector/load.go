@@ -0,0 +1,32 @@
+package collector
+
+import (
+	"context"
+
+	"github.com/lambawebdev/metrics/internal/models"
+	"github.com/shirou/gopsutil/v4/load"
+)
+
+func init() {
+	MustRegister(LoadCollector{})
+}
+
+// LoadCollector reports the 1, 5 and 15 minute load averages.
+type LoadCollector struct{}
+
+func (LoadCollector) Name() string {
+	return "load"
+}
+
+func (LoadCollector) Collect(ctx context.Context) []models.Metrics {
+	avg, err := load.AvgWithContext(ctx)
+	if err != nil {
+		return nil
+	}
+
+	return []models.Metrics{
+		gauge("Load1", avg.Load1),
+		gauge("Load5", avg.Load5),
+		gauge("Load15", avg.Load15),
+	}
+}
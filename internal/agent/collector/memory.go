@@ -0,0 +1,31 @@
+package collector
+
+import (
+	"context"
+
+	"github.com/lambawebdev/metrics/internal/models"
+	"github.com/shirou/gopsutil/v4/mem"
+)
+
+func init() {
+	MustRegister(MemoryCollector{})
+}
+
+// MemoryCollector reports the total and free memory of the host.
+type MemoryCollector struct{}
+
+func (MemoryCollector) Name() string {
+	return "memory"
+}
+
+func (MemoryCollector) Collect(ctx context.Context) []models.Metrics {
+	v, err := mem.VirtualMemoryWithContext(ctx)
+	if err != nil {
+		return nil
+	}
+
+	return []models.Metrics{
+		gauge("TotalMemory", float64(v.Total)),
+		gauge("FreeMemory", float64(v.Free)),
+	}
+}
@@ -0,0 +1,44 @@
+package collector
+
+import (
+	"context"
+
+	"github.com/lambawebdev/metrics/internal/models"
+	"github.com/shirou/gopsutil/v4/net"
+)
+
+func init() {
+	MustRegister(NetworkCollector{})
+}
+
+// NetworkCollector reports traffic, packet and error totals of every network
+// interface, labeled with interface.
+type NetworkCollector struct{}
+
+func (NetworkCollector) Name() string {
+	return "network"
+}
+
+func (NetworkCollector) Collect(ctx context.Context) []models.Metrics {
+	counters, err := net.IOCountersWithContext(ctx, true)
+	if err != nil {
+		return nil
+	}
+
+	var metrics []models.Metrics
+	for _, c := range counters {
+		labels := models.Labels{"interface": c.Name}
+		metrics = append(metrics,
+			labeled(counter("NetworkBytesSent", int64(c.BytesSent)), labels),
+			labeled(counter("NetworkBytesReceived", int64(c.BytesRecv)), labels),
+			labeled(counter("NetworkPacketsSent", int64(c.PacketsSent)), labels),
+			labeled(counter("NetworkPacketsReceived", int64(c.PacketsRecv)), labels),
+			labeled(counter("NetworkErrorsIn", int64(c.Errin)), labels),
+			labeled(counter("NetworkErrorsOut", int64(c.Errout)), labels),
+			labeled(counter("NetworkDropsIn", int64(c.Dropin)), labels),
+			labeled(counter("NetworkDropsOut", int64(c.Dropout)), labels),
+		)
+	}
+
+	return metrics
+}
@@ -0,0 +1,187 @@
+package collector
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/lambawebdev/metrics/internal/models"
+	"github.com/shirou/gopsutil/v4/process"
+)
+
+const cgroupRoot = "/sys/fs/cgroup"
+
+// ProcessTargets select the processes watched by a ProcessCollector. A
+// process matching any of them is reported.
+type ProcessTargets struct {
+	// PIDFiles are files holding the pid of a process, like nginx.pid.
+	PIDFiles []string
+	// NameRegexp matches process names, nil matches none.
+	NameRegexp *regexp.Regexp
+	// Cgroup is a cgroup v2 path, relative to /sys/fs/cgroup or absolute,
+	// all processes of which are watched.
+	Cgroup string
+}
+
+func (t ProcessTargets) Empty() bool {
+	return len(t.PIDFiles) == 0 && t.NameRegexp == nil && t.Cgroup == ""
+}
+
+// ProcessCollector reports CPU, memory, file descriptor, thread and IO usage
+// of the target processes, labeled with pid and process name. CPU usage is
+// measured since the previous poll, so a new process reports it from its
+// second poll on.
+type ProcessCollector struct {
+	targets ProcessTargets
+
+	mu        sync.Mutex
+	processes map[int32]*process.Process
+}
+
+func NewProcessCollector(targets ProcessTargets) *ProcessCollector {
+	return &ProcessCollector{
+		targets:   targets,
+		processes: make(map[int32]*process.Process),
+	}
+}
+
+func (c *ProcessCollector) Name() string {
+	return "process"
+}
+
+func (c *ProcessCollector) Collect(ctx context.Context) []models.Metrics {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pids := c.targetPIDs(ctx)
+
+	for pid := range c.processes {
+		if _, ok := pids[pid]; !ok {
+			delete(c.processes, pid)
+		}
+	}
+
+	var metrics []models.Metrics
+	for pid := range pids {
+		p, ok := c.processes[pid]
+		if !ok {
+			var err error
+			if p, err = process.NewProcessWithContext(ctx, pid); err != nil {
+				continue
+			}
+			c.processes[pid] = p
+		}
+
+		metrics = append(metrics, processMetrics(ctx, p)...)
+	}
+
+	return metrics
+}
+
+func processMetrics(ctx context.Context, p *process.Process) []models.Metrics {
+	name, err := p.NameWithContext(ctx)
+	if err != nil {
+		return nil
+	}
+
+	labels := models.Labels{"pid": strconv.Itoa(int(p.Pid)), "process": name}
+	var metrics []models.Metrics
+
+	if percent, err := p.PercentWithContext(ctx, 0); err == nil {
+		metrics = append(metrics, labeled(gauge("ProcessCPUPercent", percent), labels))
+	}
+
+	if memory, err := p.MemoryInfoWithContext(ctx); err == nil {
+		metrics = append(metrics, labeled(gauge("ProcessResidentBytes", float64(memory.RSS)), labels))
+	}
+
+	if fds, err := p.NumFDsWithContext(ctx); err == nil {
+		metrics = append(metrics, labeled(gauge("ProcessOpenFDs", float64(fds)), labels))
+	}
+
+	if threads, err := p.NumThreadsWithContext(ctx); err == nil {
+		metrics = append(metrics, labeled(gauge("ProcessThreads", float64(threads)), labels))
+	}
+
+	if io, err := p.IOCountersWithContext(ctx); err == nil {
+		metrics = append(metrics,
+			labeled(gauge("ProcessReadBytes", float64(io.ReadBytes)), labels),
+			labeled(gauge("ProcessWriteBytes", float64(io.WriteBytes)), labels),
+			labeled(gauge("ProcessReadCount", float64(io.ReadCount)), labels),
+			labeled(gauge("ProcessWriteCount", float64(io.WriteCount)), labels),
+		)
+	}
+
+	return metrics
+}
+
+func (c *ProcessCollector) targetPIDs(ctx context.Context) map[int32]struct{} {
+	pids := make(map[int32]struct{})
+
+	for _, file := range c.targets.PIDFiles {
+		if pid, err := readPID(file); err == nil {
+			pids[pid] = struct{}{}
+		}
+	}
+
+	if c.targets.NameRegexp != nil {
+		if processes, err := process.ProcessesWithContext(ctx); err == nil {
+			for _, p := range processes {
+				name, err := p.NameWithContext(ctx)
+				if err == nil && c.targets.NameRegexp.MatchString(name) {
+					pids[p.Pid] = struct{}{}
+				}
+			}
+		}
+	}
+
+	if c.targets.Cgroup != "" {
+		if cgroupPIDs, err := readCgroupPIDs(c.targets.Cgroup); err == nil {
+			for _, pid := range cgroupPIDs {
+				pids[pid] = struct{}{}
+			}
+		}
+	}
+
+	return pids
+}
+
+func readPID(file string) (int32, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return 0, err
+	}
+
+	pid, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 32)
+	if err != nil {
+		return 0, err
+	}
+
+	return int32(pid), nil
+}
+
+func readCgroupPIDs(cgroup string) ([]int32, error) {
+	dir, err := CgroupDir(cgroup)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "cgroup.procs"))
+	if err != nil {
+		return nil, err
+	}
+
+	var pids []int32
+	for _, line := range strings.Fields(string(data)) {
+		pid, err := strconv.ParseInt(line, 10, 32)
+		if err == nil {
+			pids = append(pids, int32(pid))
+		}
+	}
+
+	return pids, nil
+}
@@ -0,0 +1,135 @@
+package collector
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/lambawebdev/metrics/internal/models"
+	"github.com/shirou/gopsutil/v4/disk"
+	"github.com/shirou/gopsutil/v4/process"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func metricsByID(metrics []models.Metrics) map[string][]models.Metrics {
+	byID := make(map[string][]models.Metrics)
+	for _, m := range metrics {
+		byID[m.ID] = append(byID[m.ID], m)
+	}
+
+	return byID
+}
+
+func TestLoadCollector(t *testing.T) {
+	byID := metricsByID(LoadCollector{}.Collect(context.Background()))
+
+	for _, id := range []string{"Load1", "Load5", "Load15"} {
+		require.Len(t, byID[id], 1, id)
+		assert.GreaterOrEqual(t, *byID[id][0].Value, float64(0))
+	}
+}
+
+func TestHostCollector(t *testing.T) {
+	byID := metricsByID(HostCollector{}.Collect(context.Background()))
+
+	for _, id := range []string{"UptimeSeconds", "ProcessCount", "ThreadCount", "ProcessesRunning", "ProcessesBlocked"} {
+		require.Len(t, byID[id], 1, id)
+		assert.Equal(t, "gauge", byID[id][0].MType, id)
+	}
+
+	assert.Greater(t, *byID["UptimeSeconds"][0].Value, float64(0))
+	assert.GreaterOrEqual(t, *byID["ProcessCount"][0].Value, float64(1))
+	assert.GreaterOrEqual(t, *byID["ThreadCount"][0].Value, *byID["ProcessCount"][0].Value)
+	assert.Equal(t, "counter", byID["ContextSwitches"][0].MType)
+}
+
+func TestNetworkCollector(t *testing.T) {
+	byID := metricsByID(NetworkCollector{}.Collect(context.Background()))
+
+	var interfaces []string
+	for _, m := range byID["NetworkBytesReceived"] {
+		assert.Equal(t, "counter", m.MType)
+		interfaces = append(interfaces, m.Labels["interface"])
+	}
+	assert.Contains(t, interfaces, "lo")
+
+	for _, id := range []string{"NetworkBytesSent", "NetworkPacketsSent", "NetworkPacketsReceived", "NetworkErrorsIn", "NetworkErrorsOut", "NetworkDropsIn", "NetworkDropsOut"} {
+		assert.Len(t, byID[id], len(interfaces), id)
+	}
+}
+
+func TestFilesystemCollector(t *testing.T) {
+	partitions, err := disk.Partitions(false)
+	require.NoError(t, err)
+
+	byID := metricsByID(FilesystemCollector{}.Collect(context.Background()))
+
+	for _, m := range byID["FilesystemUsedPercent"] {
+		assert.NotEmpty(t, m.Labels["mount"])
+		assert.GreaterOrEqual(t, *m.Value, float64(0))
+		assert.LessOrEqual(t, *m.Value, float64(100))
+	}
+	assert.LessOrEqual(t, len(byID["FilesystemTotalBytes"]), len(partitions))
+	assert.Len(t, byID["FilesystemUsedBytes"], len(byID["FilesystemTotalBytes"]))
+}
+
+func TestDiskIOCollector(t *testing.T) {
+	c := &DiskIOCollector{}
+	assert.Empty(t, c.Collect(context.Background()))
+
+	time.Sleep(10 * time.Millisecond)
+
+	counters, err := disk.IOCounters()
+	require.NoError(t, err)
+
+	byID := metricsByID(c.Collect(context.Background()))
+	assert.Len(t, byID["DiskReadBytesPerSecond"], len(counters))
+
+	for _, m := range byID["DiskWriteBytesPerSecond"] {
+		assert.NotEmpty(t, m.Labels["device"])
+		assert.GreaterOrEqual(t, *m.Value, float64(0))
+	}
+}
+
+func TestProcessCollector(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "agent.pid")
+	require.NoError(t, os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644))
+
+	c := NewProcessCollector(ProcessTargets{
+		PIDFiles:   []string{pidFile, filepath.Join(t.TempDir(), "missing.pid")},
+		NameRegexp: regexp.MustCompile(`^no-such-process$`),
+	})
+
+	c.Collect(context.Background())
+	byID := metricsByID(c.Collect(context.Background()))
+
+	for _, id := range []string{"ProcessCPUPercent", "ProcessResidentBytes", "ProcessOpenFDs", "ProcessThreads"} {
+		require.Len(t, byID[id], 1, id)
+		assert.Equal(t, "gauge", byID[id][0].MType, id)
+		assert.Equal(t, strconv.Itoa(os.Getpid()), byID[id][0].Labels["pid"], id)
+		assert.NotEmpty(t, byID[id][0].Labels["process"], id)
+	}
+
+	assert.Greater(t, *byID["ProcessResidentBytes"][0].Value, float64(0))
+	assert.Greater(t, *byID["ProcessThreads"][0].Value, float64(0))
+}
+
+func TestProcessCollectorNameRegexp(t *testing.T) {
+	self, err := process.NewProcess(int32(os.Getpid()))
+	require.NoError(t, err)
+	name, err := self.Name()
+	require.NoError(t, err)
+
+	c := NewProcessCollector(ProcessTargets{NameRegexp: regexp.MustCompile("^" + regexp.QuoteMeta(name) + "$")})
+
+	var pids []string
+	for _, m := range metricsByID(c.Collect(context.Background()))["ProcessResidentBytes"] {
+		pids = append(pids, m.Labels["pid"])
+	}
+	assert.Contains(t, pids, strconv.Itoa(os.Getpid()))
+}
@@ -0,0 +1,29 @@
+package collector
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// threadCount reads the number of scheduling entities, that is threads, from
+// the fourth field of /proc/loadavg, formatted as "running/total".
+func threadCount() (int, error) {
+	data, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, err
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) < 4 {
+		return 0, fmt.Errorf("unexpected /proc/loadavg format %q", data)
+	}
+
+	_, total, ok := strings.Cut(fields[3], "/")
+	if !ok {
+		return 0, fmt.Errorf("unexpected /proc/loadavg format %q", data)
+	}
+
+	return strconv.Atoi(total)
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package collector
+
+import "errors"
+
+func threadCount() (int, error) {
+	return 0, errors.New("thread count is only supported on linux")
+}
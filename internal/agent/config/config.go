@@ -1,74 +1,249 @@
 package config
 
 import (
-	"flag"
+	"errors"
+	"fmt"
+	"net"
 	"os"
+	"regexp"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lambawebdev/metrics/internal/configload"
+	"github.com/lambawebdev/metrics/internal/models"
+	"go.uber.org/zap/zapcore"
+)
+
+// Strategies of sending to several servers.
+const (
+	StrategyFailover   = "failover"
+	StrategyRoundRobin = "round-robin"
+	StrategyFanout     = "fanout"
 )
 
-var options struct {
-	flagRunAddr           string
-	pollIntervalSeconds   uint64
-	reportIntervalSeconds uint64
-	secretKey             string
-	workerPools           uint64
+// maxRateLimit is the most requests per second the rate limiter can tick
+// for, one a nanosecond.
+const maxRateLimit = uint64(time.Second)
+
+// Config is the agent configuration. See package configload for where the
+// values come from.
+type Config struct {
+	ServerAddrs              []string      `json:"address" yaml:"address"`
+	SendStrategy             string        `json:"strategy" yaml:"strategy"`
+	HealthCheckSeconds       uint64        `json:"health_interval" yaml:"health_interval"`
+	UnhealthyCooldownSeconds uint64        `json:"unhealthy_cooldown" yaml:"unhealthy_cooldown"`
+	PollIntervalSeconds      uint64        `json:"poll_interval" yaml:"poll_interval"`
+	ReportIntervalSeconds    uint64        `json:"report_interval" yaml:"report_interval"`
+	Key                      string        `json:"key" yaml:"key"`
+	RateLimit                uint64        `json:"rate_limit" yaml:"rate_limit"`
+	Workers                  uint64        `json:"workers" yaml:"workers"`
+	BatchSize                uint64        `json:"batch_size" yaml:"batch_size"`
+	BatchWaitMillis          uint64        `json:"batch_wait" yaml:"batch_wait"`
+	AgentID                  string        `json:"id" yaml:"id"`
+	LatencyBuckets           Buckets       `json:"latency_buckets" yaml:"latency_buckets"`
+	DisabledCollectors       []string      `json:"disable_collectors" yaml:"disable_collectors"`
+	PollIntervals            PollIntervals `json:"poll_intervals" yaml:"poll_intervals"`
+	ProcessPIDFiles          []string      `json:"process_pidfiles" yaml:"process_pidfiles"`
+	ProcessNameRegexp        string        `json:"process_name" yaml:"process_name"`
+	ProcessCgroup            string        `json:"process_cgroup" yaml:"process_cgroup"`
+	CgroupPath               string        `json:"cgroup" yaml:"cgroup"`
+	StateFile                string        `json:"state_file" yaml:"state_file"`
+	SpoolDir                 string        `json:"spool_dir" yaml:"spool_dir"`
+	SpoolMaxBytes            int64         `json:"spool_max_bytes" yaml:"spool_max_bytes"`
+	SpoolMaxAgeSeconds       uint64        `json:"spool_max_age" yaml:"spool_max_age"`
+	LogLevel                 string        `json:"log_level" yaml:"log_level"`
 }
 
-func ParseFlags() {
-	flag.StringVar(&options.flagRunAddr, "a", "localhost:8080", "address and port to run server")
-	flag.Uint64Var(&options.pollIntervalSeconds, "p", 2, "poll interval for updating metrics")
-	flag.Uint64Var(&options.reportIntervalSeconds, "r", 10, "report interval for sending metrics")
-	flag.StringVar(&options.secretKey, "k", "", "set secret key")
-	flag.Uint64Var(&options.workerPools, "l", 2, "limit worker pools for send metrics")
+// Default returns the configuration used for everything not set otherwise.
+func Default() *Config {
+	return &Config{
+		ServerAddrs:              []string{"localhost:8080"},
+		SendStrategy:             StrategyFailover,
+		HealthCheckSeconds:       10,
+		UnhealthyCooldownSeconds: 30,
+		PollIntervalSeconds:      2,
+		ReportIntervalSeconds:    10,
+		RateLimit:                2,
+		Workers:                  2,
+		BatchSize:                100,
+		BatchWaitMillis:          200,
+		LatencyBuckets:           models.DefaultBuckets,
+		StateFile:                "/tmp/agent/counters.json",
+		SpoolDir:                 "/tmp/agent/spool",
+		SpoolMaxBytes:            64 << 20,
+		SpoolMaxAgeSeconds:       86400,
+		LogLevel:                 "info",
+	}
+}
 
-	flag.Parse()
+// New loads the configuration from the command line arguments, without the
+// program name, the environment and the config file they name. The agent
+// identity defaults to the hostname.
+func New(args []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	cfg := Default()
 
-	if envRunAddr := os.Getenv("ADDRESS"); envRunAddr != "" {
-		options.flagRunAddr = envRunAddr
+	if err := configload.Load(os.Args[0], args, lookupEnv, cfg, cfg.options()); err != nil {
+		return nil, err
 	}
 
-	if envPollIntervalSeconds := os.Getenv("POLL_INTERVAL"); envPollIntervalSeconds != "" {
-		value, err := strconv.ParseUint(envPollIntervalSeconds, 10, 64)
+	if cfg.AgentID == "" {
+		hostname, err := os.Hostname()
 		if err == nil {
-			options.pollIntervalSeconds = value
+			cfg.AgentID = hostname
 		}
 	}
 
-	if envReportIntervalSeconds := os.Getenv("REPORT_INTERVAL"); envReportIntervalSeconds != "" {
-		value, err := strconv.ParseUint(envReportIntervalSeconds, 10, 64)
-		if err == nil {
-			options.reportIntervalSeconds = value
-		}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
 	}
 
-	if secretKey := os.Getenv("KEY"); secretKey != "" {
-		options.secretKey = secretKey
+	return cfg, nil
+}
+
+func (c *Config) options() []configload.Option {
+	return []configload.Option{
+		{Flag: "a", Env: "ADDRESS", Usage: "comma separated addresses and ports of servers", Value: &c.ServerAddrs},
+		{Flag: "strategy", Env: "SEND_STRATEGY", Usage: "how to send to several servers: failover, round-robin or fanout", Value: &c.SendStrategy},
+		{Flag: "health-interval", Env: "HEALTH_CHECK_INTERVAL", Usage: "seconds between health checks of servers, 0 disables them", Value: &c.HealthCheckSeconds},
+		{Flag: "unhealthy-cooldown", Env: "UNHEALTHY_COOLDOWN", Usage: "seconds a failed server is left out for", Value: &c.UnhealthyCooldownSeconds},
+		{Flag: "p", Env: "POLL_INTERVAL", Usage: "poll interval for updating metrics", Value: &c.PollIntervalSeconds},
+		{Flag: "r", Env: "REPORT_INTERVAL", Usage: "report interval for sending metrics", Value: &c.ReportIntervalSeconds},
+		{Flag: "k", Env: "KEY", Usage: "set secret key", Value: &c.Key},
+		{Flag: "l", Env: "RATE_LIMIT", Usage: "max requests per second to the server, 0 is unlimited", Value: &c.RateLimit},
+		{Flag: "workers", Env: "WORKERS", Usage: "number of workers sending metrics", Value: &c.Workers},
+		{Flag: "batch-size", Env: "BATCH_SIZE", Usage: "max metrics sent in one request", Value: &c.BatchSize},
+		{Flag: "batch-wait", Env: "BATCH_WAIT", Usage: "milliseconds to wait for a batch to fill before sending it", Value: &c.BatchWaitMillis},
+		{Flag: "id", Env: "AGENT_ID", Usage: "agent identity sent with metrics, hostname by default", Value: &c.AgentID},
+		{Flag: "latency-buckets", Env: "LATENCY_BUCKETS", Usage: "comma separated upper bounds in seconds of the send latency histogram", Value: &c.LatencyBuckets},
+		{Flag: "disable-collectors", Env: "DISABLE_COLLECTORS", Usage: "comma separated names of collectors not to poll", Value: &c.DisabledCollectors},
+		{Flag: "poll-intervals", Env: "POLL_INTERVALS", Usage: "comma separated name=seconds poll intervals of single collectors", Value: &c.PollIntervals},
+		{Flag: "process-pidfiles", Env: "PROCESS_PIDFILES", Usage: "comma separated pid files of processes to watch", Value: &c.ProcessPIDFiles},
+		{Flag: "process-name", Env: "PROCESS_NAME", Usage: "regexp of names of processes to watch", Value: &c.ProcessNameRegexp},
+		{Flag: "process-cgroup", Env: "PROCESS_CGROUP", Usage: "cgroup v2 path whose processes to watch", Value: &c.ProcessCgroup},
+		{Flag: "cgroup", Env: "CGROUP_PATH", Usage: "cgroup v2 path to report usage of, the agent's own cgroup by default", Value: &c.CgroupPath},
+		{Flag: "state-file", Env: "STATE_FILE", Usage: "file keeping counter state between restarts, empty to disable", Value: &c.StateFile, KeepEmpty: true},
+		{Flag: "spool-dir", Env: "SPOOL_DIR", Usage: "directory keeping unsent batches until the server is back, empty to disable", Value: &c.SpoolDir, KeepEmpty: true},
+		{Flag: "spool-max-bytes", Env: "SPOOL_MAX_BYTES", Usage: "max size of the spool, oldest batches are dropped first", Value: &c.SpoolMaxBytes},
+		{Flag: "spool-max-age", Env: "SPOOL_MAX_AGE", Usage: "seconds after which a spooled batch is dropped", Value: &c.SpoolMaxAgeSeconds},
+		{Flag: "log-level", Env: "LOG_LEVEL", Usage: "log level: debug, info, warn or error", Value: &c.LogLevel},
 	}
+}
 
-	if envWorkerPools := os.Getenv("RATE_LIMIT"); envWorkerPools != "" {
-		value, err := strconv.ParseUint(envWorkerPools, 10, 64)
-		if err == nil {
-			options.workerPools = value
+// Validate reports every setting the agent cannot run with.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if len(c.ServerAddrs) == 0 {
+		errs = append(errs, errors.New("address: at least one server is needed"))
+	}
+
+	for _, addr := range c.ServerAddrs {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			errs = append(errs, fmt.Errorf("address: %w", err))
 		}
 	}
-}
 
-func GetFlagRunAddr() string {
-	return options.flagRunAddr
+	if !slices.Contains([]string{StrategyFailover, StrategyRoundRobin, StrategyFanout}, c.SendStrategy) {
+		errs = append(errs, fmt.Errorf("strategy: unknown strategy %q", c.SendStrategy))
+	}
+
+	if c.PollIntervalSeconds == 0 {
+		errs = append(errs, errors.New("poll_interval: must be positive"))
+	}
+
+	if c.ReportIntervalSeconds == 0 {
+		errs = append(errs, errors.New("report_interval: must be positive"))
+	}
+
+	if c.RateLimit > maxRateLimit {
+		errs = append(errs, fmt.Errorf("rate_limit: must be at most %d", maxRateLimit))
+	}
+
+	if c.Workers == 0 {
+		errs = append(errs, errors.New("workers: must be positive"))
+	}
+
+	if c.BatchSize == 0 {
+		errs = append(errs, errors.New("batch_size: must be positive"))
+	}
+
+	if len(c.LatencyBuckets) == 0 || !slices.IsSorted(c.LatencyBuckets) || len(slices.Compact(slices.Clone(c.LatencyBuckets))) != len(c.LatencyBuckets) {
+		errs = append(errs, errors.New("latency_buckets: must be increasing upper bounds"))
+	}
+
+	if _, err := regexp.Compile(c.ProcessNameRegexp); err != nil {
+		errs = append(errs, fmt.Errorf("process_name: %w", err))
+	}
+
+	if c.SpoolMaxBytes < 0 {
+		errs = append(errs, errors.New("spool_max_bytes: must not be negative"))
+	}
+
+	if _, err := zapcore.ParseLevel(c.LogLevel); err != nil {
+		errs = append(errs, fmt.Errorf("log_level: %w", err))
+	}
+
+	return errors.Join(errs...)
 }
 
-func GetFlagPollIntervalSeconds() uint64 {
-	return options.pollIntervalSeconds
+// CollectorEnabled reports whether the named collector should be polled.
+func (c *Config) CollectorEnabled(name string) bool {
+	return !slices.Contains(c.DisabledCollectors, name)
 }
 
-func GetFlagReportIntervalSeconds() uint64 {
-	return options.reportIntervalSeconds
+// CollectorPollIntervalSeconds returns the poll interval of the named
+// collector, the global poll interval unless overridden.
+func (c *Config) CollectorPollIntervalSeconds(name string) uint64 {
+	if interval, ok := c.PollIntervals[name]; ok && interval > 0 {
+		return interval
+	}
+
+	return c.PollIntervalSeconds
 }
 
-func GetSecretKey() string {
-	return options.secretKey
+// Buckets are histogram upper bounds, set from a comma separated list.
+type Buckets []float64
+
+func (b *Buckets) Set(s string) error {
+	var buckets Buckets
+
+	for _, part := range configload.SplitList(s) {
+		value, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return err
+		}
+		buckets = append(buckets, value)
+	}
+
+	slices.Sort(buckets)
+	*b = slices.Compact(buckets)
+
+	return nil
 }
 
-func GetWorkerPoolsLimit() uint64 {
-	return options.workerPools
+// PollIntervals are poll intervals in seconds by collector name, set from a
+// comma separated list of name=seconds pairs.
+type PollIntervals map[string]uint64
+
+func (p *PollIntervals) Set(s string) error {
+	intervals := make(PollIntervals)
+
+	for _, part := range configload.SplitList(s) {
+		name, seconds, ok := strings.Cut(part, "=")
+		if !ok {
+			return fmt.Errorf("%q is not name=seconds", part)
+		}
+
+		value, err := strconv.ParseUint(strings.TrimSpace(seconds), 10, 64)
+		if err != nil {
+			return err
+		}
+		intervals[strings.TrimSpace(name)] = value
+	}
+
+	*p = intervals
+
+	return nil
 }
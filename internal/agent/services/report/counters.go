@@ -0,0 +1,171 @@
+package report
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/lambawebdev/metrics/internal/models"
+)
+
+// counterTracker turns the running totals reported by collectors into the
+// deltas the server adds up. A delta is taken out when it is sent and put
+// back if sending fails, so it goes out with the next report instead. The
+// last totals and unsent deltas are saved to a state file, if one is set,
+// to survive restarts of the agent.
+type counterTracker struct {
+	mu       sync.Mutex
+	file     string
+	Reported map[string]int64 `json:"reported"`
+	Pending  map[string]int64 `json:"pending"`
+}
+
+func newCounterTracker(file string) *counterTracker {
+	return &counterTracker{
+		file:     file,
+		Reported: make(map[string]int64),
+		Pending:  make(map[string]int64),
+	}
+}
+
+// load restores the state saved by a previous run, if there is any.
+func (t *counterTracker) load() error {
+	if t.file == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(t.file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := json.Unmarshal(data, t); err != nil {
+		return err
+	}
+
+	t.Reported = upgradeKeys(t.Reported)
+	t.Pending = upgradeKeys(t.Pending)
+
+	return nil
+}
+
+// upgradeKeys converts the keys saved before series keys were quoted, as
+// "type|host|name|labels", so the counters are not sent whole again after
+// the agent is upgraded.
+func upgradeKeys(saved map[string]int64) map[string]int64 {
+	keys := make(map[string]int64, len(saved))
+
+	for key, v := range saved {
+		keys[upgradeKey(key)] = v
+	}
+
+	return keys
+}
+
+func upgradeKey(key string) string {
+	if strings.HasPrefix(key, `"`) {
+		return key
+	}
+
+	parts := strings.SplitN(key, "|", 3)
+	if len(parts) != 3 {
+		return key
+	}
+
+	// The labels are the JSON object at the end, the name is what is before.
+	i := strings.LastIndex(parts[2], "|{")
+	if i < 0 {
+		return key
+	}
+
+	var labels models.Labels
+	if err := json.Unmarshal([]byte(parts[2][i+1:]), &labels); err != nil {
+		return key
+	}
+
+	return models.Metrics{MType: parts[0], Host: parts[1], ID: parts[2][:i], Labels: labels}.Key()
+}
+
+// take returns metrics with every counter total replaced by the delta not
+// sent yet. Counters with nothing to send are left out. A total lower than
+// the previous one means the source was reset, so all of it is new.
+func (t *counterTracker) take(metrics []models.Metrics) ([]models.Metrics, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	result := make([]models.Metrics, 0, len(metrics))
+
+	for _, m := range metrics {
+		if m.MType != "counter" || m.Delta == nil {
+			result = append(result, m)
+			continue
+		}
+
+		key := m.Key()
+		total := *m.Delta
+
+		delta := total - t.Reported[key]
+		if total < t.Reported[key] {
+			delta = total
+		}
+		t.Reported[key] = total
+
+		delta += t.Pending[key]
+		delete(t.Pending, key)
+
+		if delta == 0 {
+			continue
+		}
+
+		m.Delta = &delta
+		result = append(result, m)
+	}
+
+	return result, t.save()
+}
+
+// restore puts back the deltas of counters that failed to send.
+func (t *counterTracker) restore(metrics []models.Metrics) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, m := range metrics {
+		if m.MType == "counter" && m.Delta != nil {
+			t.Pending[m.Key()] += *m.Delta
+		}
+	}
+
+	return t.save()
+}
+
+func (t *counterTracker) save() error {
+	if t.file == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(t.file), 0777); err != nil {
+		return err
+	}
+
+	tmp := t.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0666); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, t.file)
+}
@@ -0,0 +1,106 @@
+package report
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lambawebdev/metrics/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func pollCount(total int64) []models.Metrics {
+	value := float64(1)
+	return []models.Metrics{
+		{ID: "PollCount", MType: "counter", Delta: &total},
+		{ID: "Alloc", MType: "gauge", Value: &value},
+	}
+}
+
+func deltaOf(t *testing.T, metrics []models.Metrics) int64 {
+	for _, m := range metrics {
+		if m.ID == "PollCount" {
+			return *m.Delta
+		}
+	}
+
+	t.Fatal("PollCount was not sent")
+	return 0
+}
+
+func TestCounterTrackerDeltas(t *testing.T) {
+	tracker := newCounterTracker("")
+
+	var serverTotal int64
+	for _, total := range []int64{5, 10, 12} {
+		sent, err := tracker.take(pollCount(total))
+		require.NoError(t, err)
+		require.Len(t, sent, 2)
+
+		serverTotal += deltaOf(t, sent)
+		assert.Equal(t, total, serverTotal)
+	}
+
+	sent, err := tracker.take(pollCount(12))
+	require.NoError(t, err)
+	assert.Len(t, sent, 1, "counters without new polls are not sent")
+}
+
+func TestCounterTrackerCarriesFailedDeltas(t *testing.T) {
+	tracker := newCounterTracker("")
+
+	sent, err := tracker.take(pollCount(5))
+	require.NoError(t, err)
+	require.NoError(t, tracker.restore(sent))
+
+	sent, err = tracker.take(pollCount(8))
+	require.NoError(t, err)
+	assert.Equal(t, int64(8), deltaOf(t, sent))
+}
+
+func TestCounterTrackerReset(t *testing.T) {
+	tracker := newCounterTracker("")
+
+	_, err := tracker.take(pollCount(10))
+	require.NoError(t, err)
+
+	sent, err := tracker.take(pollCount(3))
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), deltaOf(t, sent))
+}
+
+func TestCounterTrackerSurvivesRestart(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "agent", "counters.json")
+
+	tracker := newCounterTracker(file)
+	_, err := tracker.take(pollCount(4))
+	require.NoError(t, err)
+
+	sent, err := tracker.take(pollCount(9))
+	require.NoError(t, err)
+	require.NoError(t, tracker.restore(sent))
+
+	restarted := newCounterTracker(file)
+	require.NoError(t, restarted.load())
+
+	sent, err = restarted.take(pollCount(2))
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), deltaOf(t, sent), "unsent delta of 5 plus 2 polls since restart")
+}
+
+func TestCounterTrackerUpgradesSavedKeys(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "counters.json")
+	saved := `{"reported": {"counter||PollCount|{}": 4}, "pending": {"counter|web-1|Requests|{\"route\":\"/a|b\"}": 3}}`
+	require.NoError(t, os.WriteFile(file, []byte(saved), 0666))
+
+	tracker := newCounterTracker(file)
+	require.NoError(t, tracker.load())
+
+	sent, err := tracker.take(pollCount(9))
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), deltaOf(t, sent))
+
+	requests := models.Metrics{ID: "Requests", MType: "counter", Host: "web-1", Labels: models.Labels{"route": "/a|b"}}
+	assert.Equal(t, int64(3), tracker.Pending[requests.Key()])
+}
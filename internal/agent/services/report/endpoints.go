@@ -0,0 +1,207 @@
+package report
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/lambawebdev/metrics/internal/agent/config"
+	"github.com/lambawebdev/metrics/internal/retry"
+	"go.uber.org/zap"
+)
+
+var errNoEndpoints = errors.New("no server addresses configured")
+
+// endpoints are the servers the agent reports to. An endpoint that failed a
+// request or a health check is left out for a cooldown. When every endpoint
+// is left out, all of them are tried anyway, as nothing would be sent
+// otherwise. Fan-out leaves none out, every endpoint must get every batch.
+type endpoints struct {
+	mu       sync.Mutex
+	addrs    []string
+	strategy string
+	cooldown time.Duration
+	downTill map[string]time.Time
+	next     int
+	now      func() time.Time
+	log      *zap.Logger
+}
+
+func newEndpoints(addrs []string, strategy string, cooldown time.Duration) *endpoints {
+	return &endpoints{
+		addrs:    addrs,
+		strategy: strategy,
+		cooldown: cooldown,
+		downTill: make(map[string]time.Time),
+		now:      time.Now,
+		log:      zap.NewNop(),
+	}
+}
+
+// targets returns the endpoints to try for one request, in order. Failover
+// starts with the first healthy endpoint, round-robin with the next one
+// after the endpoint the previous request started with.
+func (e *endpoints) targets() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var healthy []string
+	for _, addr := range e.addrs {
+		if e.now().After(e.downTill[addr]) {
+			healthy = append(healthy, addr)
+		}
+	}
+
+	if len(healthy) == 0 {
+		healthy = append(healthy, e.addrs...)
+	}
+
+	if e.strategy != config.StrategyRoundRobin || len(healthy) == 0 {
+		return healthy
+	}
+
+	start := e.next % len(healthy)
+	e.next++
+
+	return append(healthy[start:], healthy[:start]...)
+}
+
+func (e *endpoints) markDown(addr string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.downTill[addr] = e.now().Add(e.cooldown)
+}
+
+func (e *endpoints) markUp(addr string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	delete(e.downTill, addr)
+}
+
+// send sends a batch with the strategy of the endpoints. Failover and
+// round-robin move on to the next endpoint when one fails for a retryable
+// reason. Fan-out sends to every endpoint, down or not, so that each one
+// gets every batch: it fails with a retryable error while any endpoint may
+// still take the batch. Sending again is safe, as the endpoints that took
+// it already know its idempotency key.
+func (e *endpoints) send(send func(addr string) error) error {
+	if e.strategy == config.StrategyFanout {
+		return e.fanout(send)
+	}
+
+	targets := e.targets()
+	if len(targets) == 0 {
+		return errNoEndpoints
+	}
+
+	var err error
+	for _, addr := range targets {
+		err = e.sendTo(addr, send)
+		if err == nil || !retry.Retryable(err) {
+			return err
+		}
+	}
+
+	return err
+}
+
+func (e *endpoints) fanout(send func(addr string) error) error {
+	if len(e.addrs) == 0 {
+		return errNoEndpoints
+	}
+
+	errs := make([]error, len(e.addrs))
+
+	var wg sync.WaitGroup
+	for i, addr := range e.addrs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = e.sendTo(addr, send)
+		}()
+	}
+	wg.Wait()
+
+	var retryable, rejected []error
+	for _, err := range errs {
+		switch {
+		case err == nil:
+		case retry.Retryable(err):
+			retryable = append(retryable, err)
+		default:
+			rejected = append(rejected, err)
+		}
+	}
+
+	if len(retryable) > 0 {
+		return errors.Join(retryable...)
+	}
+
+	// Every endpoint that refused the batch will refuse it again. It only
+	// failed if none took it.
+	if len(rejected) == len(errs) {
+		return errors.Join(rejected...)
+	}
+
+	return nil
+}
+
+func (e *endpoints) sendTo(addr string, send func(addr string) error) error {
+	err := send(addr)
+
+	switch {
+	case err == nil:
+		e.markUp(addr)
+	case retry.Retryable(err):
+		e.markDown(addr)
+	}
+
+	return err
+}
+
+// check pings every endpoint and updates its health.
+func (e *endpoints) check(ping func(addr string) error) {
+	for _, addr := range e.addrs {
+		if err := ping(addr); err != nil {
+			e.log.Warn("Health check failed", zap.String("server", addr), zap.Error(err))
+			e.markDown(addr)
+			continue
+		}
+
+		e.markUp(addr)
+	}
+}
+
+// watch checks the health of the endpoints every interval until ctx is done.
+func (e *endpoints) watch(ctx context.Context, interval time.Duration, ping func(addr string) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			e.check(ping)
+		}
+	}
+}
+
+// ping asks a server whether it can take metrics.
+func ping(ctx context.Context, addr string) error {
+	resp, err := client.R().SetContext(ctx).Get(fmt.Sprintf("http://%s/ping", addr))
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode() != http.StatusOK {
+		return retry.NewHTTPError(resp.StatusCode(), resp.Status(), resp.Header())
+	}
+
+	return nil
+}
@@ -0,0 +1,188 @@
+package report
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lambawebdev/metrics/internal/agent/config"
+	"github.com/lambawebdev/metrics/internal/retry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeServers answer requests to the endpoints by address.
+type fakeServers struct {
+	mu   sync.Mutex
+	down map[string]error
+	got  []string
+}
+
+func (f *fakeServers) send(addr string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.down[addr]; err != nil {
+		return err
+	}
+
+	f.got = append(f.got, addr)
+	return nil
+}
+
+func (f *fakeServers) received() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	got := f.got
+	f.got = nil
+	return got
+}
+
+var refused = &net.OpError{Op: "dial", Err: errors.New("connection refused")}
+
+func TestEndpointsStrategies(t *testing.T) {
+	addrs := []string{"primary:8080", "secondary:8080", "third:8080"}
+
+	tests := []struct {
+		name     string
+		strategy string
+		down     map[string]error
+		want     [][]string
+	}{
+		{
+			name:     "Test failover to primary",
+			strategy: config.StrategyFailover,
+			want:     [][]string{{"primary:8080"}, {"primary:8080"}},
+		},
+		{
+			name:     "Test failover to secondary",
+			strategy: config.StrategyFailover,
+			down:     map[string]error{"primary:8080": refused},
+			want:     [][]string{{"secondary:8080"}, {"secondary:8080"}},
+		},
+		{
+			name:     "Test round robin",
+			strategy: config.StrategyRoundRobin,
+			want:     [][]string{{"primary:8080"}, {"secondary:8080"}, {"third:8080"}, {"primary:8080"}},
+		},
+		{
+			name:     "Test round robin skips unhealthy",
+			strategy: config.StrategyRoundRobin,
+			down:     map[string]error{"secondary:8080": refused},
+			want:     [][]string{{"primary:8080"}, {"third:8080"}, {"primary:8080"}, {"third:8080"}},
+		},
+		{
+			name:     "Test fanout",
+			strategy: config.StrategyFanout,
+			want:     [][]string{{"primary:8080", "secondary:8080", "third:8080"}},
+		},
+		{
+			name:     "Test fanout to endpoints refusing some batches",
+			strategy: config.StrategyFanout,
+			down:     map[string]error{"third:8080": &retry.HTTPError{StatusCode: http.StatusBadRequest}},
+			want:     [][]string{{"primary:8080", "secondary:8080"}, {"primary:8080", "secondary:8080"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := newEndpoints(addrs, test.strategy, time.Minute)
+			f := &fakeServers{down: test.down}
+
+			for _, want := range test.want {
+				require.NoError(t, e.send(f.send))
+				assert.ElementsMatch(t, want, f.received())
+			}
+		})
+	}
+}
+
+func TestEndpointsFanoutEndpointBack(t *testing.T) {
+	e := newEndpoints([]string{"primary:8080", "secondary:8080"}, config.StrategyFanout, time.Minute)
+	f := &fakeServers{down: map[string]error{"secondary:8080": &retry.HTTPError{StatusCode: http.StatusBadGateway}}}
+
+	// The batch must be sent again until the secondary has it too.
+	err := e.send(f.send)
+	require.Error(t, err)
+	assert.True(t, retry.Retryable(err))
+	assert.Equal(t, []string{"primary:8080"}, f.received())
+
+	// The secondary is tried while in cooldown, and gets the batch once back.
+	f.down = nil
+	require.NoError(t, e.send(f.send))
+	assert.ElementsMatch(t, []string{"primary:8080", "secondary:8080"}, f.received())
+}
+
+func TestEndpointsFanoutAllRejected(t *testing.T) {
+	e := newEndpoints([]string{"primary:8080", "secondary:8080"}, config.StrategyFanout, time.Minute)
+	rejectedErr := &retry.HTTPError{StatusCode: http.StatusBadRequest}
+	f := &fakeServers{down: map[string]error{"primary:8080": rejectedErr, "secondary:8080": rejectedErr}}
+
+	err := e.send(f.send)
+	require.ErrorIs(t, err, rejectedErr)
+	assert.False(t, retry.Retryable(err))
+}
+
+func TestEndpointsNotRetryable(t *testing.T) {
+	e := newEndpoints([]string{"primary:8080", "secondary:8080"}, config.StrategyFailover, time.Minute)
+	f := &fakeServers{down: map[string]error{"primary:8080": &retry.HTTPError{StatusCode: http.StatusBadRequest}}}
+
+	// A batch the server rejects would be rejected by the others too.
+	require.Error(t, e.send(f.send))
+	assert.Empty(t, f.received())
+	assert.Equal(t, []string{"primary:8080", "secondary:8080"}, e.targets())
+}
+
+func TestEndpointsAllDown(t *testing.T) {
+	e := newEndpoints([]string{"primary:8080", "secondary:8080"}, config.StrategyFailover, time.Minute)
+	f := &fakeServers{down: map[string]error{"primary:8080": refused, "secondary:8080": refused}}
+
+	err := e.send(f.send)
+	require.ErrorIs(t, err, refused)
+	assert.True(t, retry.Retryable(err))
+
+	// All endpoints are down, so all of them are tried again.
+	assert.Equal(t, []string{"primary:8080", "secondary:8080"}, e.targets())
+
+	f.down = nil
+	require.NoError(t, e.send(f.send))
+	assert.Equal(t, []string{"primary:8080"}, f.received())
+}
+
+func TestEndpointsHealthCheck(t *testing.T) {
+	now := time.Now()
+	e := newEndpoints([]string{"primary:8080", "secondary:8080"}, config.StrategyFailover, time.Minute)
+	e.now = func() time.Time { return now }
+
+	healthy := map[string]bool{"primary:8080": false, "secondary:8080": true}
+	check := func(addr string) error {
+		if !healthy[addr] {
+			return refused
+		}
+		return nil
+	}
+
+	e.check(check)
+	assert.Equal(t, []string{"secondary:8080"}, e.targets())
+
+	// The primary is back, but not before the next check.
+	healthy["primary:8080"] = true
+	assert.Equal(t, []string{"secondary:8080"}, e.targets())
+
+	e.check(check)
+	assert.Equal(t, []string{"primary:8080", "secondary:8080"}, e.targets())
+
+	// A failed endpoint comes back by itself after the cooldown.
+	e.markDown("primary:8080")
+	now = now.Add(2 * time.Minute)
+	assert.Equal(t, []string{"primary:8080", "secondary:8080"}, e.targets())
+}
+
+func TestEndpointsNone(t *testing.T) {
+	e := newEndpoints(nil, config.StrategyRoundRobin, time.Minute)
+
+	assert.ErrorIs(t, e.send(func(string) error { return nil }), errNoEndpoints)
+}
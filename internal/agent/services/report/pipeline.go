@@ -0,0 +1,210 @@
+package report
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/lambawebdev/metrics/internal/models"
+	"go.uber.org/zap"
+)
+
+// pipeline sends queued metrics in batches from a fixed pool of workers. The
+// batcher cuts a batch once it holds batchSize metrics or its first metric
+// has waited for batchWait. Workers take a token from the rate limiter before
+// every request. Cancelling the context stops the pipeline: what is still
+// queued is kept for later rather than sent.
+type pipeline struct {
+	workers   int
+	batchSize int
+	batchWait time.Duration
+	rateLimit uint64
+	send      func(ctx context.Context, metrics []models.Metrics) error
+	keep      func(key string, metrics []models.Metrics)
+	// spill makes metrics that do not fit in the queue go to keep instead
+	// of waiting for room, for when keep puts them in a spool.
+	spill bool
+	log   *zap.Logger
+
+	queue   chan models.Metrics
+	batches chan []models.Metrics
+	limiter *time.Ticker
+	// tokens are the rate limiter ticks, nil if there is no limit.
+	tokens <-chan time.Time
+	wg     sync.WaitGroup
+
+	// mu is held by enqueue while it queues, and by the batcher to set
+	// stopped before it drains the queue for the last time.
+	mu      sync.RWMutex
+	stopped bool
+}
+
+func newPipeline(workers, batchSize int, batchWait time.Duration, rateLimit uint64, send func(ctx context.Context, metrics []models.Metrics) error, keep func(key string, metrics []models.Metrics)) *pipeline {
+	if workers < 1 {
+		workers = 1
+	}
+
+	if batchSize < 1 {
+		batchSize = 1
+	}
+
+	return &pipeline{
+		workers:   workers,
+		batchSize: batchSize,
+		batchWait: batchWait,
+		rateLimit: rateLimit,
+		send:      send,
+		keep:      keep,
+		log:       zap.NewNop(),
+		queue:     make(chan models.Metrics, batchSize),
+		batches:   make(chan []models.Metrics, workers),
+	}
+}
+
+// start runs the batcher and the workers until ctx is cancelled.
+func (p *pipeline) start(ctx context.Context) {
+	if p.rateLimit > 0 {
+		p.limiter = time.NewTicker(time.Second / time.Duration(p.rateLimit))
+		p.tokens = p.limiter.C
+	}
+
+	p.wg.Add(1)
+	go p.batch(ctx)
+
+	for w := 0; w < p.workers; w++ {
+		p.wg.Add(1)
+		go p.work(ctx)
+	}
+}
+
+// wait blocks until the pipeline has stopped.
+func (p *pipeline) wait() {
+	p.wg.Wait()
+
+	if p.limiter != nil {
+		p.limiter.Stop()
+	}
+}
+
+// enqueue queues metrics for sending. It waits for room in the queue, unless
+// the pipeline spills. ctx is the one the pipeline was started with: once it
+// is cancelled, metrics go to keep, as the batcher may have drained the
+// queue for the last time.
+func (p *pipeline) enqueue(ctx context.Context, metrics []models.Metrics) {
+	var overflow []models.Metrics
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for _, m := range metrics {
+		if p.stopped || ctx.Err() != nil {
+			overflow = append(overflow, m)
+			continue
+		}
+
+		if !p.spill {
+			select {
+			case p.queue <- m:
+			case <-ctx.Done():
+				overflow = append(overflow, m)
+			}
+			continue
+		}
+
+		select {
+		case p.queue <- m:
+		default:
+			overflow = append(overflow, m)
+		}
+	}
+
+	if len(overflow) > 0 {
+		p.keep("", overflow)
+	}
+}
+
+func (p *pipeline) batch(ctx context.Context) {
+	defer p.wg.Done()
+	defer close(p.batches)
+
+	var batch []models.Metrics
+	var timeout <-chan time.Time
+
+	flush := func() {
+		if len(batch) > 0 {
+			p.batches <- batch
+		}
+		batch = nil
+		timeout = nil
+	}
+
+	add := func(m models.Metrics) {
+		if len(batch) == 0 {
+			timeout = time.After(p.batchWait)
+		}
+
+		batch = append(batch, m)
+		if len(batch) >= p.batchSize {
+			flush()
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			// Waits for enqueue to finish queueing, so nothing is queued
+			// after the last drain.
+			p.mu.Lock()
+			p.stopped = true
+			p.mu.Unlock()
+
+			for {
+				select {
+				case m := <-p.queue:
+					add(m)
+				default:
+					flush()
+					return
+				}
+			}
+		case m := <-p.queue:
+			add(m)
+		case <-timeout:
+			flush()
+		}
+	}
+}
+
+// take waits for a token of the rate limiter, so that every request counts
+// against the limit. It reports false if ctx was cancelled first.
+func (p *pipeline) take(ctx context.Context) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	if p.tokens == nil {
+		return true
+	}
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-p.tokens:
+		return true
+	}
+}
+
+func (p *pipeline) work(ctx context.Context) {
+	defer p.wg.Done()
+
+	for batch := range p.batches {
+		if !p.take(ctx) {
+			p.keep("", batch)
+			continue
+		}
+
+		if err := p.send(ctx, batch); err != nil {
+			p.log.Error("Batch not sent", zap.Int("metrics", len(batch)), zap.Error(err))
+		}
+	}
+}
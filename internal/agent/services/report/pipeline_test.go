@@ -0,0 +1,200 @@
+package report
+
+import (
+	"context"
+	"runtime"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lambawebdev/metrics/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recorder stands in for the server and the spool of a pipeline.
+type recorder struct {
+	mu      sync.Mutex
+	batches []int
+	kept    int
+}
+
+func (r *recorder) send(_ context.Context, metrics []models.Metrics) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.batches = append(r.batches, len(metrics))
+	return nil
+}
+
+func (r *recorder) keep(_ string, metrics []models.Metrics) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.kept += len(metrics)
+}
+
+func (r *recorder) sent() []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sent := append([]int(nil), r.batches...)
+	sort.Ints(sent)
+	return sent
+}
+
+func gauges(n int) []models.Metrics {
+	metrics := make([]models.Metrics, n)
+	for i := range metrics {
+		value := float64(i)
+		metrics[i] = models.Metrics{ID: "Alloc", MType: "gauge", Value: &value}
+	}
+
+	return metrics
+}
+
+func TestPipelineBatches(t *testing.T) {
+	tests := []struct {
+		name      string
+		batchSize int
+		metrics   int
+		want      []int
+	}{
+		{
+			name:      "Test full batches",
+			batchSize: 10,
+			metrics:   30,
+			want:      []int{10, 10, 10},
+		},
+		{
+			name:      "Test partial batch sent after wait",
+			batchSize: 10,
+			metrics:   25,
+			want:      []int{5, 10, 10},
+		},
+		{
+			name:      "Test single metric",
+			batchSize: 10,
+			metrics:   1,
+			want:      []int{1},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			var r recorder
+			p := newPipeline(2, test.batchSize, 20*time.Millisecond, 0, r.send, r.keep)
+			p.start(ctx)
+
+			p.enqueue(ctx, gauges(test.metrics))
+
+			assert.Eventually(t, func() bool {
+				return assert.ObjectsAreEqual(test.want, r.sent())
+			}, time.Second, 5*time.Millisecond)
+
+			cancel()
+			p.wait()
+			assert.Equal(t, 0, r.kept)
+		})
+	}
+}
+
+func TestPipelineRateLimit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var r recorder
+	p := newPipeline(4, 1, time.Millisecond, 20, r.send, r.keep)
+	p.start(ctx)
+
+	started := time.Now()
+	p.enqueue(ctx, gauges(5))
+
+	require.Eventually(t, func() bool {
+		return len(r.sent()) == 5
+	}, 2*time.Second, 5*time.Millisecond)
+
+	// 5 requests at 20 per second take at least 4 intervals of 50ms.
+	assert.GreaterOrEqual(t, time.Since(started), 200*time.Millisecond)
+
+	cancel()
+	p.wait()
+}
+
+func TestPipelineTake(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var r recorder
+	p := newPipeline(1, 1, time.Millisecond, 20, r.send, r.keep)
+	p.start(ctx)
+
+	// Requests outside the pipeline, like spool replays, share its limit.
+	started := time.Now()
+	for i := 0; i < 5; i++ {
+		require.True(t, p.take(ctx))
+	}
+	assert.GreaterOrEqual(t, time.Since(started), 200*time.Millisecond)
+
+	cancel()
+	assert.False(t, p.take(ctx))
+	p.wait()
+}
+
+func TestPipelineShutdown(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var r recorder
+	p := newPipeline(3, 10, time.Hour, 0, r.send, r.keep)
+	p.start(ctx)
+
+	running := runtime.NumGoroutine()
+
+	// Report ticks must reuse the workers instead of starting new ones.
+	for i := 0; i < 20; i++ {
+		p.enqueue(ctx, gauges(10))
+	}
+	assert.Equal(t, running, runtime.NumGoroutine())
+
+	require.Eventually(t, func() bool {
+		return len(r.sent()) == 20
+	}, time.Second, 5*time.Millisecond)
+
+	// A partial batch is waiting for the hour long batch wait.
+	p.enqueue(ctx, gauges(5))
+
+	cancel()
+	p.wait()
+
+	// The partial batch is kept for later instead of sent on shutdown.
+	assert.Len(t, r.sent(), 20)
+	assert.Equal(t, 5, r.kept)
+
+	// Polled by hand, as assert.Eventually runs goroutines of its own.
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before)
+}
+
+func TestPipelineEnqueueAfterCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var r recorder
+	p := newPipeline(1, 100, time.Hour, 0, r.send, r.keep)
+	p.start(ctx)
+
+	cancel()
+	p.wait()
+
+	// The queue has room, but nothing would take the metrics out of it.
+	p.enqueue(ctx, gauges(5))
+
+	assert.Empty(t, r.sent())
+	assert.Equal(t, 5, r.kept)
+}
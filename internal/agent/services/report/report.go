@@ -1,318 +1,516 @@
 package report
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
 	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"math/rand"
+	"net/http"
 	"os"
-	"reflect"
-	"runtime"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-resty/resty/v2"
+	"github.com/lambawebdev/metrics/internal/agent/collector"
 	"github.com/lambawebdev/metrics/internal/agent/config"
 	"github.com/lambawebdev/metrics/internal/models"
-	"github.com/lambawebdev/metrics/internal/validators"
-	"github.com/shirou/gopsutil/v4/mem"
+	"github.com/lambawebdev/metrics/internal/retry"
+	"go.uber.org/zap"
 )
 
-type Monitor struct {
-	Alloc           uint64
-	BuckHashSys     uint64
-	Frees           uint64
-	GCCPUFraction   float64
-	GCSys           uint64
-	HeapAlloc       uint64
-	HeapIdle        uint64
-	HeapInuse       uint64
-	HeapObjects     uint64
-	HeapReleased    uint64
-	HeapSys         uint64
-	LastGC          uint64
-	Lookups         uint64
-	MCacheInuse     uint64
-	MCacheSys       uint64
-	MSpanInuse      uint64
-	MSpanSys        uint64
-	Mallocs         uint64
-	NextGC          uint64
-	NumForcedGC     uint32
-	NumGC           uint32
-	OtherSys        uint64
-	PauseTotalNs    uint64
-	StackInuse      uint64
-	StackSys        uint64
-	Sys             uint64
-	TotalAlloc      uint64
-	PollCount       uint64
-	RandomValue     uint64
-	TotalMemory     float64
-	FreeMemory      float64
-	CPUutilization1 float64
+// latencyHistogram collects send latencies between two reports.
+type latencyHistogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	h       *models.Histogram
 }
 
-func Start() {
-	var m Monitor
+func (l *latencyHistogram) observe(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 
-	//32 метрики всего
-	ch := make(chan models.Metrics, 32)
+	if l.h == nil {
+		l.h = models.NewHistogram(l.buckets)
+	}
+	l.h.Observe(d.Seconds())
+}
 
-	pollTicker := time.NewTicker(time.Duration(config.GetFlagPollIntervalSeconds()) * time.Second)
-	defer pollTicker.Stop()
+// flush returns the latencies observed since the previous flush, nil if
+// there were none.
+func (l *latencyHistogram) flush() *models.Histogram {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 
-	reportTicker := time.NewTicker(time.Duration(config.GetFlagReportIntervalSeconds()) * time.Second)
-	defer reportTicker.Stop()
+	h := l.h
+	l.h = nil
 
-	for {
-		select {
-		case <-pollTicker.C:
-			m = GetRuntimeMetrics(m)
-			m = GetAdditionalMetrics(m)
-		case <-reportTicker.C:
-			SendMetrics(m, ch)
-		}
-	}
+	return h
 }
 
-func GetRuntimeMetrics(m Monitor) Monitor {
-	var rtm runtime.MemStats
-
-	runtime.ReadMemStats(&rtm)
-	m.Alloc = rtm.Alloc
-	m.BuckHashSys = rtm.BuckHashSys
-	m.Frees = rtm.Frees
-	m.GCCPUFraction = rtm.GCCPUFraction
-	m.GCSys = rtm.GCSys
-	m.HeapAlloc = rtm.HeapAlloc
-	m.HeapIdle = rtm.HeapIdle
-	m.HeapInuse = rtm.HeapInuse
-	m.HeapObjects = rtm.HeapObjects
-	m.HeapReleased = rtm.HeapReleased
-	m.HeapSys = rtm.HeapSys
-	m.LastGC = rtm.LastGC
-	m.Lookups = rtm.Lookups
-	m.MCacheInuse = rtm.MCacheInuse
-	m.MCacheSys = rtm.MCacheSys
-	m.MSpanInuse = rtm.MSpanInuse
-	m.MSpanSys = rtm.MSpanSys
-	m.Mallocs = rtm.Mallocs
-	m.NextGC = rtm.NextGC
-	m.NumForcedGC = rtm.NumForcedGC
-	m.NumGC = rtm.NumGC
-	m.OtherSys = rtm.OtherSys
-	m.PauseTotalNs = rtm.PauseTotalNs
-	m.StackInuse = rtm.StackInuse
-	m.StackSys = rtm.StackSys
-	m.Sys = rtm.Sys
-	m.TotalAlloc = rtm.TotalAlloc
-	m.RandomValue = rand.Uint64()
-
-	m.PollCount++
-
-	return m
+// reporter sends the metrics of the collectors to the servers.
+type reporter struct {
+	config      *config.Config
+	sendLatency *latencyHistogram
+	counters    *counterTracker
+	servers     *endpoints
+	// outbox keeps the batches that failed to send, nil if the spool is
+	// disabled.
+	outbox *spool
+	retry  retry.Policy
+	log    *zap.Logger
+	// rejected counts the batches dropped because the server refused them.
+	rejected atomic.Int64
 }
 
-func GetAdditionalMetrics(m Monitor) Monitor {
-	v, _ := mem.VirtualMemory()
+func newReporter(cfg *config.Config, log *zap.Logger) *reporter {
+	cooldown := time.Duration(cfg.UnhealthyCooldownSeconds) * time.Second
 
-	m.TotalMemory = float64(v.Total)
-	m.FreeMemory = float64(v.Free)
-	m.CPUutilization1 = float64(v.HugePagesFree)
+	r := &reporter{
+		config:      cfg,
+		sendLatency: &latencyHistogram{buckets: cfg.LatencyBuckets},
+		counters:    newCounterTracker(cfg.StateFile),
+		servers:     newEndpoints(cfg.ServerAddrs, cfg.SendStrategy, cooldown),
+		retry:       sendPolicy,
+		log:         log,
+	}
+	r.servers.log = log
+	r.retry.OnRetry = func(attempt int, err error, backoff time.Duration) {
+		log.Warn("Send failed, retrying",
+			zap.Int("attempt", attempt),
+			zap.Duration("backoff", backoff),
+			zap.Error(err),
+		)
+	}
+
+	if cfg.SpoolDir != "" {
+		maxAge := time.Duration(cfg.SpoolMaxAgeSeconds) * time.Second
+		r.outbox = newSpool(cfg.SpoolDir, cfg.SpoolMaxBytes, maxAge)
+		r.outbox.log = log
+	}
 
-	return m
+	return r
 }
 
-func SendMetrics(m Monitor, ch chan models.Metrics) {
-	//итератор по m структуре и записываем в канал
-	writeMetricToChannel(m, ch)
+// snapshot keeps the metrics of the latest poll of every collector.
+type snapshot struct {
+	mu      sync.Mutex
+	names   []string
+	metrics map[string][]models.Metrics
+}
+
+func (s *snapshot) set(name string, metrics []models.Metrics) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.metrics == nil {
+		s.metrics = make(map[string][]models.Metrics)
+	}
 
-	//worker pool 2 go routine и в каждую go рутину передаем канал
-	for w := uint64(1); w <= config.GetWorkerPoolsLimit(); w++ {
-		go worker(w, ch)
+	if _, ok := s.metrics[name]; !ok {
+		s.names = append(s.names, name)
 	}
+	s.metrics[name] = metrics
 }
 
-func SendMetricsBatch(m Monitor) {
-	metrics := prepareMetrics(m)
-	sendMetricsBatchReq(metrics)
+func (s *snapshot) all() []models.Metrics {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var all []models.Metrics
+	for _, name := range s.names {
+		all = append(all, s.metrics[name]...)
+	}
+
+	return all
 }
 
-func worker(id uint64, metrics <-chan models.Metrics) {
-	fmt.Println("woker", id)
-	for metric := range metrics {
-		for _, backoff := range backoffSchedule {
-			fmt.Println(metric.Delta, metric.Value)
-			err := sendMetricReq(metric)
+// Start polls the collectors and reports their metrics until ctx is
+// cancelled. It returns once the send pipeline has stopped.
+func Start(ctx context.Context, cfg *config.Config, log *zap.Logger) {
+	var s snapshot
 
-			if err == nil {
-				break
-			}
+	r := newReporter(cfg, log)
 
-			fmt.Fprintf(os.Stderr, "Request error: %+v\n", err)
-			fmt.Fprintf(os.Stderr, "Retrying in %v\n", backoff)
-			time.Sleep(backoff)
+	if err := r.counters.load(); err != nil {
+		log.Error("Counter state not loaded", zap.String("path", cfg.StateFile), zap.Error(err))
+	}
+
+	if cfg.HealthCheckSeconds > 0 {
+		go r.servers.watch(ctx, time.Duration(cfg.HealthCheckSeconds)*time.Second, func(addr string) error {
+			return ping(ctx, addr)
+		})
+	}
+
+	if r.outbox != nil {
+		if err := collector.Register(r.outbox); err != nil {
+			log.Error("Collector not registered", zap.String("collector", r.outbox.Name()), zap.Error(err))
 		}
 	}
-}
 
-func writeMetricToChannel(m Monitor, ch chan<- models.Metrics) {
-	var monitor = reflect.ValueOf(m)
+	if err := registerProcessCollector(cfg); err != nil {
+		log.Error("Collector not registered", zap.String("collector", "process"), zap.Error(err))
+	}
 
-	for metricType, metrics := range validators.TypesMetrics() {
-		for _, metricName := range metrics {
-			metricValue := reflect.Indirect(monitor).FieldByName(metricName)
+	if err := registerCgroupCollector(cfg); err != nil {
+		log.Error("Collector not registered", zap.String("collector", "cgroup"), zap.Error(err))
+	}
 
-			metrics := models.Metrics{}
-			metrics.ID = metricName
-			metrics.MType = metricType
+	for _, c := range collector.Registered() {
+		if !cfg.CollectorEnabled(c.Name()) {
+			continue
+		}
 
-			k := metricValue.Kind()
+		interval := time.Duration(cfg.CollectorPollIntervalSeconds(c.Name())) * time.Second
+		if starter, ok := c.(collector.Starter); ok {
+			starter.Start(ctx, interval)
+		}
 
-			if metricType == "gauge" {
-				if k == reflect.Uint32 {
-					value := float64(metricValue.Interface().(uint32))
-					metrics.Value = &value
-				}
+		go poll(ctx, c, interval, &s)
+	}
 
-				if k == reflect.Uint64 {
-					value := float64(metricValue.Interface().(uint64))
-					metrics.Value = &value
-				}
+	batchWait := time.Duration(cfg.BatchWaitMillis) * time.Millisecond
+	pipe := newPipeline(int(cfg.Workers), int(cfg.BatchSize), batchWait, cfg.RateLimit, r.sendBatch, r.keep)
+	pipe.spill = r.outbox != nil
+	pipe.log = log
+	pipe.start(ctx)
+	defer pipe.wait()
 
-				if k == reflect.Float64 {
-					value := float64(metricValue.Interface().(float64))
-					metrics.Value = &value
-				}
-			}
+	reportTicker := time.NewTicker(time.Duration(cfg.ReportIntervalSeconds) * time.Second)
+	defer reportTicker.Stop()
 
-			if metricType == "counter" {
-				value := int64(metricValue.Interface().(uint64))
-				metrics.Delta = &value
-			}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-reportTicker.C:
+			r.report(ctx, s.all(), pipe)
+		}
+	}
+}
 
-			ch <- metrics
+// report queues the deltas of metrics for sending, or spools them behind the
+// batches that still wait for the server.
+func (r *reporter) report(ctx context.Context, metrics []models.Metrics, pipe *pipeline) {
+	rejected := r.rejected.Load()
+	metrics = append(metrics, models.Metrics{ID: "RejectedBatches", MType: "counter", Delta: &rejected})
+
+	if r.outbox != nil && !r.replaySpool(ctx, pipe) {
+		// The server is still unreachable. Queue the report behind the
+		// spooled ones rather than sending it ahead of them.
+		metrics, err := r.takeDeltas(metrics)
+		if err != nil {
+			r.log.Error("Counter state not saved", zap.Error(err))
 		}
+		r.keep("", metrics)
+		return
+	}
+
+	metrics, err := r.takeDeltas(metrics)
+	if err != nil {
+		r.log.Error("Counter state not saved", zap.Error(err))
 	}
+
+	if h := r.sendLatency.flush(); h != nil {
+		metrics = append(metrics, models.Metrics{ID: "SendLatency", MType: "histogram", Host: r.config.AgentID, Histogram: h})
+	}
+
+	pipe.enqueue(ctx, metrics)
 }
 
-func prepareMetrics(m Monitor) []models.Metrics {
-	var monitor = reflect.ValueOf(m)
-	var batch []models.Metrics
+// replaySpool sends the spooled batches and reports whether all were sent.
+// They take their turn at the rate limiter of pipe like any other batch.
+func (r *reporter) replaySpool(ctx context.Context, pipe *pipeline) bool {
+	err := r.outbox.replay(func(key string, metrics []models.Metrics) error {
+		if !pipe.take(ctx) {
+			return ctx.Err()
+		}
+		return r.sendWithRetry(ctx, key, metrics)
+	})
+	if err != nil {
+		r.log.Warn("Spool not replayed", zap.Error(err))
+		return false
+	}
 
-	for metricType, metrics := range validators.TypesMetrics() {
-		for _, metricName := range metrics {
-			metricValue := reflect.Indirect(monitor).FieldByName(metricName)
+	return true
+}
 
-			metrics := models.Metrics{}
-			metrics.ID = metricName
-			metrics.MType = metricType
+// keep holds on to metrics that failed to send: in the spool if there is
+// one, otherwise as counter deltas pending for the next report.
+func (r *reporter) keep(key string, metrics []models.Metrics) {
+	if r.outbox != nil {
+		err := r.outbox.push(key, metrics)
+		if err == nil {
+			return
+		}
+		r.log.Error("Batch not spooled", zap.String("idempotency_key", key), zap.Int("metrics", len(metrics)), zap.Error(err))
+	}
 
-			k := metricValue.Kind()
+	if err := r.counters.restore(metrics); err != nil {
+		r.log.Error("Counter state not saved", zap.Error(err))
+	}
+}
 
-			if metricType == "gauge" {
-				if k == reflect.Uint32 {
-					value := float64(metricValue.Interface().(uint32))
-					metrics.Value = &value
-				}
+// registerProcessCollector watches the processes selected in the config, if
+// any are.
+func registerProcessCollector(cfg *config.Config) error {
+	targets := collector.ProcessTargets{
+		PIDFiles: cfg.ProcessPIDFiles,
+		Cgroup:   cfg.ProcessCgroup,
+	}
 
-				if k == reflect.Uint64 {
-					value := float64(metricValue.Interface().(uint64))
-					metrics.Value = &value
-				}
+	if cfg.ProcessNameRegexp != "" {
+		re, err := regexp.Compile(cfg.ProcessNameRegexp)
+		if err != nil {
+			return err
+		}
+		targets.NameRegexp = re
+	}
 
-				if k == reflect.Float64 {
-					value := float64(metricValue.Interface().(float64))
-					metrics.Value = &value
-				}
-			}
+	if targets.Empty() {
+		return nil
+	}
 
-			if metricType == "counter" {
-				value := int64(metricValue.Interface().(uint64))
-				metrics.Delta = &value
-			}
+	return collector.Register(collector.NewProcessCollector(targets))
+}
 
-			batch = append(batch, metrics)
+// registerCgroupCollector watches the configured cgroup or, by default, the
+// agent's own one when running on a cgroup v2 host.
+func registerCgroupCollector(cfg *config.Config) error {
+	dir, err := collector.CgroupDir(cfg.CgroupPath)
+	if err != nil {
+		if cfg.CgroupPath == "" {
+			return nil
 		}
+		return err
 	}
 
-	return batch
+	if _, err := os.Stat(filepath.Join(dir, "cgroup.controllers")); err != nil {
+		if cfg.CgroupPath == "" {
+			return nil
+		}
+		return err
+	}
+
+	return collector.Register(collector.NewCgroupCollector(dir))
 }
 
-var client = resty.New()
+func poll(ctx context.Context, c collector.Collector, interval time.Duration, s *snapshot) {
+	pollTicker := time.NewTicker(interval)
+	defer pollTicker.Stop()
 
-func sendMetricReq(metrics models.Metrics) error {
-	body, err := json.Marshal(metrics)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-pollTicker.C:
+			s.set(c.Name(), c.Collect(ctx))
+		}
+	}
+}
 
+// sendBatch sends one batch, retrying on failure. A batch that could not be
+// sent is kept to go out later, unless the server refused it: sending it
+// again would fail the same way and hold up every batch behind it.
+func (r *reporter) sendBatch(ctx context.Context, metrics []models.Metrics) error {
+	// The key stays the same across retries, so the server applies the batch
+	// once even if an attempt it received timed out on our side.
+	key, err := newIdempotencyKey()
 	if err != nil {
+		r.keep("", metrics)
 		return err
 	}
 
-	url := fmt.Sprintf("http://%s/update/", config.GetFlagRunAddr())
+	err = r.sendWithRetry(ctx, key, metrics)
+	switch {
+	case err == nil:
+		return nil
+	case rejected(err):
+		r.rejected.Add(1)
+		r.log.Error("Batch rejected, dropping it", zap.String("idempotency_key", key), zap.Int("metrics", len(metrics)), zap.Error(err))
+	default:
+		r.keep(key, metrics)
+	}
 
-	request := client.R().
-		SetHeader("Content-Type", "application/json").
-		SetHeader("Content-Encoding", "gzip").
-		SetBody(body)
+	return err
+}
 
-	if config.GetSecretKey() != "" {
-		secretKey := []byte(config.GetSecretKey())
-		hmac, err := getHmacBody(body, secretKey)
+// rejected reports whether err means the server refused a batch for good,
+// rather than being out of reach for now or the send being cancelled.
+func rejected(err error) bool {
+	return err != nil &&
+		!retry.Retryable(err) &&
+		!errors.Is(err, retry.ErrOpen) &&
+		!errors.Is(err, context.Canceled) &&
+		!errors.Is(err, context.DeadlineExceeded)
+}
+
+func (r *reporter) sendWithRetry(ctx context.Context, key string, metrics []models.Metrics) error {
+	return r.retry.Do(ctx, func() error {
+		err := r.servers.send(func(addr string) error {
+			started := time.Now()
+			refused, err := sendMetricsBatchReq(ctx, addr, r.config, key, metrics)
+			r.sendLatency.observe(time.Since(started))
+
+			for _, m := range refused {
+				if m.Index >= 0 && m.Index < len(metrics) {
+					r.log.Warn("Metric rejected", zap.String("metric", metrics[m.Index].ID), zap.String("type", metrics[m.Index].MType), zap.String("code", m.Code), zap.String("reason", m.Message))
+				}
+			}
 
-		if err != nil {
 			return err
-		}
+		})
 
-		request.SetHeader("HashSHA256", hmac)
-	}
+		if err != nil {
+			r.log.Debug("Request failed", zap.String("idempotency_key", key), zap.Int("metrics", len(metrics)), zap.Error(err))
+		}
 
-	_, err = request.Post(url)
-	if err != nil {
 		return err
+	})
+}
+
+// takeDeltas sets the agent identity of metrics and replaces the totals of
+// counters with the deltas not sent yet.
+func (r *reporter) takeDeltas(metrics []models.Metrics) ([]models.Metrics, error) {
+	identified := make([]models.Metrics, len(metrics))
+	for i, m := range metrics {
+		m.Host = r.config.AgentID
+		identified[i] = m
 	}
 
-	return nil
+	return r.counters.take(identified)
 }
 
-func sendMetricsBatchReq(metrics []models.Metrics) error {
+// requestTimeout bounds every request to a server, so that one that hangs
+// cannot hold up a worker.
+const requestTimeout = 10 * time.Second
+
+var client = resty.New().SetTimeout(requestTimeout)
+
+// rejectedMetric is a metric of a batch the server stored the rest of, by
+// its index in the batch.
+type rejectedMetric struct {
+	Index   int    `json:"index"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// sendMetricsBatchReq sends a batch to the server at addr and returns the
+// metrics it left out, if any.
+func sendMetricsBatchReq(ctx context.Context, addr string, cfg *config.Config, key string, metrics []models.Metrics) ([]rejectedMetric, error) {
 	body, err := json.Marshal(metrics)
 
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	url := fmt.Sprintf("http://%s/updates/", addr)
+
+	compressed, err := compress(body)
+	if err != nil {
+		return nil, err
 	}
 
-	url := fmt.Sprintf("http://%s/updates/", config.GetFlagRunAddr())
+	requestID, traceparent, err := newTraceHeaders()
+	if err != nil {
+		return nil, err
+	}
 
 	request := client.R().
+		SetContext(ctx).
 		SetHeader("Content-Type", "application/json").
 		SetHeader("Content-Encoding", "gzip").
-		SetBody(body)
+		SetHeader("Idempotency-Key", key).
+		SetHeader("X-Request-ID", requestID).
+		SetHeader("X-Agent-ID", cfg.AgentID).
+		SetHeader("traceparent", traceparent).
+		SetBody(compressed)
 
-	if config.GetSecretKey() != "" {
-		secretKey := []byte(config.GetSecretKey())
-		hmac, err := getHmacBody(body, secretKey)
+	if cfg.Key != "" {
+		hmac, err := getHmacBody(body, []byte(cfg.Key))
 
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		request.SetHeader("HashSHA256", hmac)
 	}
 
-	_, err = request.Post(url)
+	resp, err := request.Post(url)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("request %s: %w", requestID, err)
+	}
+
+	if resp.StatusCode() != http.StatusOK {
+		return nil, fmt.Errorf("request %s: %w", requestID, retry.NewHTTPError(resp.StatusCode(), resp.Status(), resp.Header()))
+	}
+
+	// Servers that store batches whole answer without a body.
+	var result struct {
+		Rejected []rejectedMetric `json:"rejected"`
+	}
+	if len(resp.Body()) > 0 && json.Unmarshal(resp.Body(), &result) == nil {
+		return result.Rejected, nil
 	}
 
-	return nil
+	return nil, nil
 }
 
-var backoffSchedule = []time.Duration{
-	1 * time.Second,
-	3 * time.Second,
-	5 * time.Second,
+// newTraceHeaders returns a new request ID and a W3C traceparent starting a
+// sampled trace, for the server to log and trace the request under.
+func newTraceHeaders() (string, string, error) {
+	ids := make([]byte, 16+16+8)
+	if _, err := rand.Read(ids); err != nil {
+		return "", "", err
+	}
+
+	requestID := hex.EncodeToString(ids[:16])
+	traceparent := fmt.Sprintf("00-%s-%s-01", hex.EncodeToString(ids[16:32]), hex.EncodeToString(ids[32:]))
+
+	return requestID, traceparent, nil
+}
+
+// newIdempotencyKey returns a random key identifying one batch.
+func newIdempotencyKey() (string, error) {
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(key), nil
+}
+
+// sendPolicy retries requests to the server. Its breaker is shared by all
+// workers, so they stop sending once the server stops answering.
+var sendPolicy = retry.Policy{
+	InitialInterval: time.Second,
+	MaxInterval:     5 * time.Second,
+	Multiplier:      2,
+	MaxElapsedTime:  15 * time.Second,
+	Breaker:         retry.NewBreaker(5, 30*time.Second),
+}
+
+func compress(body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(body); err != nil {
+		return nil, err
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
 }
 
 func getHmacBody(msg []byte, key []byte) (string, error) {
@@ -0,0 +1,165 @@
+package report
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lambawebdev/metrics/internal/agent/config"
+	"github.com/lambawebdev/metrics/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+var traceparentRegexp = regexp.MustCompile(`^00-[0-9a-f]{32}-[0-9a-f]{16}-01$`)
+
+func TestSendMetricsBatchReqTraceHeaders(t *testing.T) {
+	var headers []http.Header
+	status := http.StatusOK
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = append(headers, r.Header.Clone())
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	addr := strings.TrimPrefix(srv.URL, "http://")
+	cfg := config.Default()
+	cfg.AgentID = "web-1"
+	value := float64(1)
+	metrics := []models.Metrics{{ID: "Alloc", MType: "gauge", Value: &value}}
+
+	_, err := sendMetricsBatchReq(context.Background(), addr, cfg, "batch-1", metrics)
+	require.NoError(t, err)
+
+	status = http.StatusInternalServerError
+	_, err = sendMetricsBatchReq(context.Background(), addr, cfg, "batch-1", metrics)
+	require.Error(t, err)
+
+	require.Len(t, headers, 2)
+	for _, h := range headers {
+		assert.Equal(t, "web-1", h.Get("X-Agent-ID"))
+		assert.Len(t, h.Get("X-Request-ID"), 32)
+		assert.Regexp(t, traceparentRegexp, h.Get("traceparent"))
+	}
+
+	// Every send is a request of its own, even for the same batch.
+	assert.NotEqual(t, headers[0].Get("X-Request-ID"), headers[1].Get("X-Request-ID"))
+	assert.NotEqual(t, headers[0].Get("traceparent"), headers[1].Get("traceparent"))
+
+	// The ID of a failed request is in its error, to find it in the server logs.
+	assert.Contains(t, err.Error(), headers[1].Get("X-Request-ID"))
+}
+
+func TestSendMetricsBatchReqCancelled(t *testing.T) {
+	hang := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		<-hang
+	}))
+	defer srv.Close()
+	defer close(hang)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	value := float64(1)
+	_, err := sendMetricsBatchReq(ctx, strings.TrimPrefix(srv.URL, "http://"), config.Default(), "batch-1", []models.Metrics{{ID: "Alloc", MType: "gauge", Value: &value}})
+
+	// A server that hangs does not hold up shutdown.
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.False(t, rejected(err))
+}
+
+func TestSendWithRetryLogsAttempts(t *testing.T) {
+	failures := 2
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	cfg := config.Default()
+	cfg.ServerAddrs = []string{strings.TrimPrefix(srv.URL, "http://")}
+	cfg.StateFile = ""
+	cfg.SpoolDir = ""
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	r := newReporter(cfg, zap.New(core))
+	r.retry.InitialInterval = time.Millisecond
+	r.retry.MaxInterval = time.Millisecond
+	r.retry.Breaker = nil
+
+	value := float64(1)
+	metrics := []models.Metrics{{ID: "Alloc", MType: "gauge", Value: &value}}
+	require.NoError(t, r.sendWithRetry(context.Background(), "batch-1", metrics))
+
+	retries := logs.FilterMessage("Send failed, retrying").All()
+	require.Len(t, retries, 2)
+	for i, entry := range retries {
+		fields := entry.ContextMap()
+		assert.Equal(t, zapcore.WarnLevel, entry.Level)
+		assert.Equal(t, int64(i), fields["attempt"])
+		assert.Contains(t, fields, "backoff")
+		assert.Contains(t, fields["error"], "503")
+	}
+}
+
+func TestSendWithRetryLogsRejectedMetrics(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		io.WriteString(w, `{"accepted": 1, "rejected": [{"index": 1, "code": "metric_name_invalid", "message": "Metric name is not supported!"}]}`)
+	}))
+	defer srv.Close()
+
+	cfg := config.Default()
+	cfg.ServerAddrs = []string{strings.TrimPrefix(srv.URL, "http://")}
+	cfg.StateFile = ""
+	cfg.SpoolDir = ""
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	r := newReporter(cfg, zap.New(core))
+
+	value := float64(1)
+	metrics := []models.Metrics{{ID: "Alloc", MType: "gauge", Value: &value}, {ID: "Heap-Alloc", MType: "gauge", Value: &value}}
+	require.NoError(t, r.sendWithRetry(context.Background(), "batch-1", metrics))
+
+	rejected := logs.FilterMessage("Metric rejected").All()
+	require.Len(t, rejected, 1)
+	assert.Equal(t, "Heap-Alloc", rejected[0].ContextMap()["metric"])
+	assert.Equal(t, "metric_name_invalid", rejected[0].ContextMap()["code"])
+}
+
+func TestSendBatchDropsRejected(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	cfg := config.Default()
+	cfg.ServerAddrs = []string{strings.TrimPrefix(srv.URL, "http://")}
+	cfg.StateFile = ""
+	cfg.SpoolDir = t.TempDir()
+
+	r := newReporter(cfg, zap.NewNop())
+	r.retry.Breaker = nil
+
+	value := float64(1)
+	require.Error(t, r.sendBatch(context.Background(), []models.Metrics{{ID: "Alloc", MType: "gauge", Value: &value}}))
+
+	// Sending it again would be refused as well, so it is not spooled.
+	batches, _, err := r.outbox.depth()
+	require.NoError(t, err)
+	assert.Equal(t, 0, batches)
+	assert.Equal(t, int64(1), r.rejected.Load())
+}
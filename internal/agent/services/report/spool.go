@@ -0,0 +1,247 @@
+package report
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lambawebdev/metrics/internal/models"
+	"go.uber.org/zap"
+)
+
+// spool keeps batches the server did not accept in a directory, one file per
+// batch, until they can be replayed. File names start with the time the
+// batch was spooled, so listing them in name order replays them in the order
+// they were produced. When the spool grows past its size or a batch past its
+// age, the oldest batches are dropped first.
+type spool struct {
+	mu       sync.Mutex
+	dir      string
+	maxBytes int64
+	maxAge   time.Duration
+	seq      uint64
+	dropped  int64
+	log      *zap.Logger
+}
+
+// spooledBatch is the content of a spool file. The idempotency key is kept
+// with the metrics, so a batch the server applied before the agent gave up
+// on it is not applied twice on replay.
+type spooledBatch struct {
+	Key     string           `json:"key"`
+	Metrics []models.Metrics `json:"metrics"`
+}
+
+func newSpool(dir string, maxBytes int64, maxAge time.Duration) *spool {
+	return &spool{
+		dir:      dir,
+		maxBytes: maxBytes,
+		maxAge:   maxAge,
+		log:      zap.NewNop(),
+	}
+}
+
+// push appends a batch to the spool. A batch that was never sent has no
+// idempotency key yet and is given one.
+func (s *spool) push(key string, metrics []models.Metrics) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	if key == "" {
+		var err error
+		if key, err = newIdempotencyKey(); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(spooledBatch{Key: key, Metrics: metrics})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(s.dir, 0777); err != nil {
+		return err
+	}
+
+	s.seq++
+	name := fmt.Sprintf("%020d-%010d.json", time.Now().UnixNano(), s.seq)
+
+	tmp := filepath.Join(s.dir, name+".tmp")
+	if err := os.WriteFile(tmp, data, 0666); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, filepath.Join(s.dir, name)); err != nil {
+		return err
+	}
+
+	return s.evict()
+}
+
+// replay sends the spooled batches oldest first and removes every batch
+// that was sent or that the server refused. It stops at the first other
+// failure, so the order is kept.
+func (s *spool) replay(send func(key string, metrics []models.Metrics) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.evict(); err != nil {
+		return err
+	}
+
+	files, err := s.files()
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		path := filepath.Join(s.dir, file.Name())
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		var batch spooledBatch
+		if err := json.Unmarshal(data, &batch); err != nil {
+			// A batch that cannot be read will never be sent.
+			s.dropped++
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := send(batch.Key, batch.Metrics); err != nil {
+			if !rejected(err) {
+				return err
+			}
+			s.dropped++
+			s.log.Error("Spooled batch rejected, dropping it", zap.String("idempotency_key", batch.Key), zap.Int("metrics", len(batch.Metrics)), zap.Error(err))
+		}
+
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// depth returns the number of spooled batches and their size in bytes.
+func (s *spool) depth() (int, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files, err := s.files()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var size int64
+	for _, file := range files {
+		size += file.Size()
+	}
+
+	return len(files), size, nil
+}
+
+// evict drops the batches older than the max age, then the oldest ones
+// until the spool fits in its max size.
+func (s *spool) evict() error {
+	files, err := s.files()
+	if err != nil {
+		return err
+	}
+
+	var size int64
+	for _, file := range files {
+		size += file.Size()
+	}
+
+	for _, file := range files {
+		expired := s.maxAge > 0 && time.Since(file.ModTime()) > s.maxAge
+		oversized := s.maxBytes > 0 && size > s.maxBytes
+
+		if !expired && !oversized {
+			break
+		}
+
+		if err := os.Remove(filepath.Join(s.dir, file.Name())); err != nil {
+			return err
+		}
+
+		size -= file.Size()
+		s.dropped++
+	}
+
+	return nil
+}
+
+// files lists the spooled batches oldest first.
+func (s *spool) files() ([]fs.FileInfo, error) {
+	entries, err := os.ReadDir(s.dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var files []fs.FileInfo
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, info)
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name() < files[j].Name()
+	})
+
+	return files, nil
+}
+
+// Name and Collect report the state of the spool as the agent's own metrics.
+func (s *spool) Name() string {
+	return "spool"
+}
+
+func (s *spool) Collect(_ context.Context) []models.Metrics {
+	batches, size, err := s.depth()
+	if err != nil {
+		s.log.Error("Spool depth not read", zap.String("path", s.dir), zap.Error(err))
+		return nil
+	}
+
+	s.mu.Lock()
+	dropped := s.dropped
+	s.mu.Unlock()
+
+	queueBatches := float64(batches)
+	queueBytes := float64(size)
+
+	return []models.Metrics{
+		{ID: "SpoolQueueBatches", MType: "gauge", Value: &queueBatches},
+		{ID: "SpoolQueueBytes", MType: "gauge", Value: &queueBytes},
+		{ID: "SpoolDroppedBatches", MType: "counter", Delta: &dropped},
+	}
+}
@@ -0,0 +1,168 @@
+package report
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lambawebdev/metrics/internal/models"
+	"github.com/lambawebdev/metrics/internal/retry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpoolReplayInOrder(t *testing.T) {
+	s := newSpool(t.TempDir(), 0, 0)
+
+	require.NoError(t, s.push("first", pollCount(1)))
+	require.NoError(t, s.push("second", pollCount(2)))
+	require.NoError(t, s.push("", pollCount(3)))
+
+	var keys []string
+	var totals []int64
+	send := func(key string, metrics []models.Metrics) error {
+		keys = append(keys, key)
+		totals = append(totals, deltaOf(t, metrics))
+		return nil
+	}
+
+	require.NoError(t, s.replay(send))
+	assert.Equal(t, []int64{1, 2, 3}, totals)
+	assert.Equal(t, "first", keys[0])
+	assert.Equal(t, "second", keys[1])
+	assert.NotEmpty(t, keys[2])
+
+	batches, _, err := s.depth()
+	require.NoError(t, err)
+	assert.Equal(t, 0, batches)
+}
+
+func TestSpoolReplayStopsAtFailure(t *testing.T) {
+	s := newSpool(t.TempDir(), 0, 0)
+
+	require.NoError(t, s.push("first", pollCount(1)))
+	require.NoError(t, s.push("second", pollCount(2)))
+
+	sent := 0
+	send := func(key string, metrics []models.Metrics) error {
+		if key == "second" {
+			return &net.OpError{Op: "dial", Err: errors.New("connection refused")}
+		}
+		sent++
+		return nil
+	}
+
+	require.Error(t, s.replay(send))
+	assert.Equal(t, 1, sent)
+
+	batches, _, err := s.depth()
+	require.NoError(t, err)
+	assert.Equal(t, 1, batches)
+
+	var keys []string
+	require.NoError(t, s.replay(func(key string, metrics []models.Metrics) error {
+		keys = append(keys, key)
+		return nil
+	}))
+	assert.Equal(t, []string{"second"}, keys)
+}
+
+func TestSpoolReplayDropsRejected(t *testing.T) {
+	s := newSpool(t.TempDir(), 0, 0)
+
+	require.NoError(t, s.push("bad", pollCount(1)))
+	require.NoError(t, s.push("good", pollCount(2)))
+
+	var sent []string
+	send := func(key string, metrics []models.Metrics) error {
+		if key == "bad" {
+			return fmt.Errorf("request 1: %w", retry.NewHTTPError(http.StatusBadRequest, "400 Bad Request", nil))
+		}
+		sent = append(sent, key)
+		return nil
+	}
+
+	// The rejected batch at the head does not hold up the one behind it.
+	require.NoError(t, s.replay(send))
+	assert.Equal(t, []string{"good"}, sent)
+
+	batches, _, err := s.depth()
+	require.NoError(t, err)
+	assert.Equal(t, 0, batches)
+
+	collected := s.Collect(context.Background())
+	require.Len(t, collected, 3)
+	assert.Equal(t, "SpoolDroppedBatches", collected[2].ID)
+	assert.Equal(t, int64(1), *collected[2].Delta)
+}
+
+func TestSpoolEviction(t *testing.T) {
+	tests := []struct {
+		name    string
+		maxSize func(batchSize int64) int64
+		maxAge  time.Duration
+		age     time.Duration
+		want    []string
+	}{
+		{
+			name:    "Test no limits",
+			maxSize: func(int64) int64 { return 0 },
+			want:    []string{"batch-1", "batch-2", "batch-3"},
+		},
+		{
+			name:    "Test oldest dropped when too big",
+			maxSize: func(batchSize int64) int64 { return 2 * batchSize },
+			want:    []string{"batch-2", "batch-3"},
+		},
+		{
+			name:    "Test old batches dropped",
+			maxSize: func(int64) int64 { return 0 },
+			maxAge:  time.Hour,
+			age:     2 * time.Hour,
+			want:    []string{"batch-3"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			probe := newSpool(filepath.Join(dir, "probe"), 0, 0)
+			require.NoError(t, probe.push("batch-1", pollCount(1)))
+			_, batchSize, err := probe.depth()
+			require.NoError(t, err)
+
+			s := newSpool(filepath.Join(dir, "spool"), test.maxSize(batchSize), test.maxAge)
+			require.NoError(t, s.push("batch-1", pollCount(1)))
+			require.NoError(t, s.push("batch-2", pollCount(1)))
+
+			if test.age > 0 {
+				files, err := s.files()
+				require.NoError(t, err)
+
+				old := time.Now().Add(-test.age)
+				for _, file := range files {
+					require.NoError(t, os.Chtimes(filepath.Join(s.dir, file.Name()), old, old))
+				}
+			}
+
+			require.NoError(t, s.push("batch-3", pollCount(1)))
+
+			var keys []string
+			require.NoError(t, s.replay(func(key string, metrics []models.Metrics) error {
+				keys = append(keys, key)
+				return nil
+			}))
+			assert.Equal(t, test.want, keys)
+
+			collected := s.Collect(context.Background())
+			require.Len(t, collected, 3)
+			assert.Equal(t, int64(3-len(test.want)), *collected[2].Delta)
+		})
+	}
+}
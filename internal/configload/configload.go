@@ -0,0 +1,185 @@
+// Package configload fills a config struct from a config file, environment
+// variables and command line flags. Each source overrides the previous one:
+//
+//	defaults < config file < environment < flags
+//
+// The config file is named by the -c flag or the CONFIG variable. Files
+// ending in .json are read as JSON, all others as YAML. Unknown keys and
+// values that do not parse are errors rather than silently ignored.
+package configload
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Option binds a config field to a flag and an environment variable.
+type Option struct {
+	Flag  string
+	Env   string
+	Usage string
+	// Value points to the config field. It is a *string, *bool, *uint64,
+	// *int64, *[]string or a Setter.
+	Value interface{}
+	// KeepEmpty makes an empty environment variable set the field, so it
+	// can turn off what is on by default.
+	KeepEmpty bool
+}
+
+// Setter is a field type parsed from flags and variables by itself.
+type Setter interface {
+	Set(s string) error
+}
+
+// Load fills cfg, which holds the defaults, from the sources in order of
+// precedence. args are the command line arguments without the program name.
+// It returns every invalid value it found, or flag.ErrHelp if asked for
+// usage.
+func Load(name string, args []string, lookupEnv func(string) (string, bool), cfg interface{}, options []Option) error {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+
+	configFile := fs.String("c", "", "config file, JSON or YAML")
+
+	type flagValue struct {
+		option Option
+		value  string
+	}
+	var flagged []flagValue
+
+	for _, option := range options {
+		usage := option.Usage
+		if option.Env != "" {
+			usage = fmt.Sprintf("%s (env %s)", usage, option.Env)
+		}
+
+		record := func(s string) error {
+			flagged = append(flagged, flagValue{option: option, value: s})
+			return nil
+		}
+
+		if _, ok := option.Value.(*bool); ok {
+			fs.BoolFunc(option.Flag, usage, record)
+		} else {
+			fs.Func(option.Flag, usage, record)
+		}
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *configFile == "" {
+		*configFile, _ = lookupEnv("CONFIG")
+	}
+
+	if *configFile != "" {
+		if err := readFile(*configFile, cfg); err != nil {
+			return fmt.Errorf("config file %s: %w", *configFile, err)
+		}
+	}
+
+	var errs []error
+
+	for _, option := range options {
+		if option.Env == "" {
+			continue
+		}
+
+		value, ok := lookupEnv(option.Env)
+		if !ok || (value == "" && !option.KeepEmpty) {
+			continue
+		}
+
+		if err := set(option.Value, value); err != nil {
+			errs = append(errs, fmt.Errorf("env %s: %w", option.Env, err))
+		}
+	}
+
+	for _, f := range flagged {
+		if err := set(f.option.Value, f.value); err != nil {
+			errs = append(errs, fmt.Errorf("flag -%s: %w", f.option.Flag, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func readFile(path string, cfg interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		return decoder.Decode(cfg)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	err = decoder.Decode(cfg)
+	if errors.Is(err, io.EOF) {
+		// An empty file changes nothing.
+		return nil
+	}
+
+	return err
+}
+
+func set(value interface{}, s string) error {
+	switch v := value.(type) {
+	case Setter:
+		return v.Set(s)
+	case *string:
+		*v = s
+	case *bool:
+		parsed, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		*v = parsed
+	case *uint64:
+		parsed, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		*v = parsed
+	case *int64:
+		parsed, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		*v = parsed
+	case *[]string:
+		*v = SplitList(s)
+	default:
+		return fmt.Errorf("unsupported option type %T", value)
+	}
+
+	return nil
+}
+
+// SplitList splits a comma separated list, leaving out empty items.
+func SplitList(s string) []string {
+	var items []string
+
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
@@ -0,0 +1,175 @@
+package configload
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testConfig struct {
+	Address  string   `json:"address" yaml:"address"`
+	Interval uint64   `json:"interval" yaml:"interval"`
+	Restore  bool     `json:"restore" yaml:"restore"`
+	State    string   `json:"state" yaml:"state"`
+	Servers  []string `json:"servers" yaml:"servers"`
+}
+
+func defaults() *testConfig {
+	return &testConfig{Address: "localhost:8080", Interval: 300, Restore: true, State: "/tmp/state"}
+}
+
+func (c *testConfig) options() []Option {
+	return []Option{
+		{Flag: "a", Env: "ADDRESS", Value: &c.Address},
+		{Flag: "i", Env: "INTERVAL", Value: &c.Interval},
+		{Flag: "r", Env: "RESTORE", Value: &c.Restore},
+		{Flag: "state", Env: "STATE", Value: &c.State, KeepEmpty: true},
+		{Flag: "servers", Env: "SERVERS", Value: &c.Servers},
+	}
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	yamlFile := writeFile(t, "config.yaml", "address: file:1\ninterval: 10\n")
+	jsonFile := writeFile(t, "config.json", `{"address": "file:1", "interval": 10}`)
+
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		want *testConfig
+	}{
+		{
+			name: "Test defaults",
+			want: defaults(),
+		},
+		{
+			name: "Test yaml file over defaults",
+			args: []string{"-c", yamlFile},
+			want: &testConfig{Address: "file:1", Interval: 10, Restore: true, State: "/tmp/state"},
+		},
+		{
+			name: "Test json file over defaults",
+			env:  map[string]string{"CONFIG": jsonFile},
+			want: &testConfig{Address: "file:1", Interval: 10, Restore: true, State: "/tmp/state"},
+		},
+		{
+			name: "Test env over file",
+			args: []string{"-c", yamlFile},
+			env:  map[string]string{"ADDRESS": "env:1"},
+			want: &testConfig{Address: "env:1", Interval: 10, Restore: true, State: "/tmp/state"},
+		},
+		{
+			name: "Test flag over env",
+			args: []string{"-a", "flag:1", "-r=false"},
+			env:  map[string]string{"ADDRESS": "env:1", "RESTORE": "true"},
+			want: &testConfig{Address: "flag:1", Interval: 300, Restore: false, State: "/tmp/state"},
+		},
+		{
+			name: "Test flag over env over file",
+			args: []string{"-c", yamlFile, "-i", "20"},
+			env:  map[string]string{"ADDRESS": "env:1", "INTERVAL": "15"},
+			want: &testConfig{Address: "env:1", Interval: 20, Restore: true, State: "/tmp/state"},
+		},
+		{
+			name: "Test empty env ignored",
+			env:  map[string]string{"ADDRESS": ""},
+			want: defaults(),
+		},
+		{
+			name: "Test empty env kept",
+			env:  map[string]string{"STATE": ""},
+			want: &testConfig{Address: "localhost:8080", Interval: 300, Restore: true},
+		},
+		{
+			name: "Test list",
+			env:  map[string]string{"SERVERS": "a:1, ,b:2"},
+			want: &testConfig{Address: "localhost:8080", Interval: 300, Restore: true, State: "/tmp/state", Servers: []string{"a:1", "b:2"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := defaults()
+			lookupEnv := func(key string) (string, bool) {
+				value, ok := test.env[key]
+				return value, ok
+			}
+
+			require.NoError(t, Load("test", test.args, lookupEnv, cfg, cfg.options()))
+			assert.Equal(t, test.want, cfg)
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		want []string
+	}{
+		{
+			name: "Test invalid env",
+			env:  map[string]string{"INTERVAL": "abc"},
+			want: []string{"env INTERVAL"},
+		},
+		{
+			name: "Test invalid flag",
+			args: []string{"-i", "-1"},
+			want: []string{"flag -i"},
+		},
+		{
+			name: "Test every invalid value",
+			args: []string{"-r=maybe"},
+			env:  map[string]string{"INTERVAL": "abc"},
+			want: []string{"env INTERVAL", "flag -r"},
+		},
+		{
+			name: "Test unknown yaml key",
+			args: []string{"-c", writeFile(t, "config.yml", "adress: file:1\n")},
+			want: []string{"adress"},
+		},
+		{
+			name: "Test unknown json key",
+			args: []string{"-c", writeFile(t, "config.json", `{"adress": "file:1"}`)},
+			want: []string{"adress"},
+		},
+		{
+			name: "Test missing file",
+			args: []string{"-c", filepath.Join(t.TempDir(), "missing.yaml")},
+			want: []string{"missing.yaml"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := defaults()
+			lookupEnv := func(key string) (string, bool) {
+				value, ok := test.env[key]
+				return value, ok
+			}
+
+			err := Load("test", test.args, lookupEnv, cfg, cfg.options())
+			require.Error(t, err)
+			for _, want := range test.want {
+				assert.Contains(t, err.Error(), want)
+			}
+		})
+	}
+}
+
+func TestLoadHelp(t *testing.T) {
+	cfg := defaults()
+	noEnv := func(string) (string, bool) { return "", false }
+
+	err := Load("test", []string{"-h"}, noEnv, cfg, cfg.options())
+	assert.ErrorIs(t, err, flag.ErrHelp)
+}
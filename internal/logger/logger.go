@@ -0,0 +1,34 @@
+// Package logger holds the zap logger shared by the server and the agent.
+package logger
+
+import (
+	"go.uber.org/zap"
+)
+
+var Log *zap.Logger = zap.NewNop()
+
+// level is shared by every logger built by Initialize, so SetLevel changes
+// it without a rebuild.
+var level = zap.NewAtomicLevel()
+
+func Initialize(lvl string) error {
+	if err := SetLevel(lvl); err != nil {
+		return err
+	}
+
+	cfg := zap.NewProductionConfig()
+	cfg.Level = level
+
+	zl, err := cfg.Build()
+	if err != nil {
+		return err
+	}
+
+	Log = zl
+	return nil
+}
+
+// SetLevel changes the level of the running logger.
+func SetLevel(lvl string) error {
+	return level.UnmarshalText([]byte(lvl))
+}
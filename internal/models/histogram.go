@@ -0,0 +1,90 @@
+package models
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"sort"
+)
+
+var ErrBucketsMismatch = errors.New("histogram buckets do not match")
+
+// DefaultBuckets are latency bounds in seconds.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Histogram counts observations into buckets with inclusive upper bounds.
+// Counts has one more element than Buckets, the last one counts everything
+// above the largest bound. Counts are not cumulative.
+type Histogram struct {
+	Buckets []float64 `json:"buckets"`
+	Counts  []uint64  `json:"counts"`
+	Sum     float64   `json:"sum"`
+	Count   uint64    `json:"count"`
+}
+
+func NewHistogram(buckets []float64) *Histogram {
+	return &Histogram{
+		Buckets: slices.Clone(buckets),
+		Counts:  make([]uint64, len(buckets)+1),
+	}
+}
+
+// Clone returns a copy of h that shares nothing with it.
+func (h *Histogram) Clone() *Histogram {
+	return &Histogram{
+		Buckets: slices.Clone(h.Buckets),
+		Counts:  slices.Clone(h.Counts),
+		Sum:     h.Sum,
+		Count:   h.Count,
+	}
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.Buckets, v)
+	h.Counts[i]++
+	h.Sum += v
+	h.Count++
+}
+
+// Merge adds the observations of other, which must have the same buckets
+// and one count per bucket plus one, like h.
+func (h *Histogram) Merge(other *Histogram) error {
+	if !slices.Equal(h.Buckets, other.Buckets) || len(h.Counts) != len(other.Counts) || len(h.Counts) != len(h.Buckets)+1 {
+		return ErrBucketsMismatch
+	}
+
+	for i, c := range other.Counts {
+		h.Counts[i] += c
+	}
+	h.Sum += other.Sum
+	h.Count += other.Count
+
+	return nil
+}
+
+func (h *Histogram) Validate() error {
+	if len(h.Counts) != len(h.Buckets)+1 {
+		return errors.New("histogram must have one count per bucket plus one for +Inf")
+	}
+
+	for i, b := range h.Buckets {
+		if math.IsNaN(b) || math.IsInf(b, 0) || (i > 0 && b <= h.Buckets[i-1]) {
+			return errors.New("histogram buckets must be finite and strictly ascending")
+		}
+	}
+
+	if math.IsNaN(h.Sum) || math.IsInf(h.Sum, 0) {
+		return errors.New("histogram sum must be finite")
+	}
+
+	var total uint64
+	for _, c := range h.Counts {
+		total += c
+	}
+
+	if total != h.Count {
+		return errors.New("histogram count must equal the sum of bucket counts")
+	}
+
+	return nil
+}
@@ -0,0 +1,150 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Labels are optional dimensions of a metric such as region or disk. Two
+// metrics with the same name but different labels are different series.
+type Labels map[string]string
+
+func (l Labels) Equal(other Labels) bool {
+	if len(l) != len(other) {
+		return false
+	}
+
+	for k, v := range l {
+		if ov, ok := other[k]; !ok || ov != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// String formats labels as a JSON object with sorted keys, so equal labels
+// always give the same string.
+func (l Labels) String() string {
+	v, _ := l.Value()
+	return v.(string)
+}
+
+// Value stores labels as a JSON object, an empty object for no labels.
+func (l Labels) Value() (driver.Value, error) {
+	if len(l) == 0 {
+		return "{}", nil
+	}
+
+	data, err := json.Marshal(map[string]string(l))
+	if err != nil {
+		return nil, err
+	}
+
+	return string(data), nil
+}
+
+func (l *Labels) Scan(src interface{}) error {
+	var data []byte
+
+	switch v := src.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("unsupported labels type %T", src)
+	}
+
+	var m map[string]string
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+
+	if len(m) == 0 {
+		*l = nil
+		return nil
+	}
+
+	*l = m
+	return nil
+}
+
+type MatchType string
+
+const (
+	MatchEqual     MatchType = "="
+	MatchNotEqual  MatchType = "!="
+	MatchRegexp    MatchType = "=~"
+	MatchNotRegexp MatchType = "!~"
+)
+
+// Matcher selects series by the value of one label. A missing label matches
+// as an empty value, so `disk!=""` keeps only series that have a disk label.
+type Matcher struct {
+	Name  string
+	Type  MatchType
+	Value string
+	re    *regexp.Regexp
+}
+
+// ParseMatcher parses expressions like `region="eu"`, `disk!=sda` or
+// `service=~"api.*"`. Regular expressions are anchored at both ends.
+func ParseMatcher(s string) (Matcher, error) {
+	i := strings.IndexAny(s, "=!")
+	if i <= 0 {
+		return Matcher{}, fmt.Errorf("invalid matcher %q", s)
+	}
+
+	m := Matcher{Name: strings.TrimSpace(s[:i])}
+	rest := s[i:]
+
+	switch {
+	case strings.HasPrefix(rest, string(MatchRegexp)):
+		m.Type = MatchRegexp
+	case strings.HasPrefix(rest, string(MatchNotRegexp)):
+		m.Type = MatchNotRegexp
+	case strings.HasPrefix(rest, string(MatchNotEqual)):
+		m.Type = MatchNotEqual
+	case strings.HasPrefix(rest, string(MatchEqual)):
+		m.Type = MatchEqual
+	default:
+		return Matcher{}, fmt.Errorf("invalid matcher %q", s)
+	}
+
+	m.Value = strings.Trim(strings.TrimSpace(rest[len(m.Type):]), `"`)
+
+	if m.Type == MatchRegexp || m.Type == MatchNotRegexp {
+		re, err := regexp.Compile("^(?:" + m.Value + ")$")
+		if err != nil {
+			return Matcher{}, errors.Join(fmt.Errorf("invalid matcher %q", s), err)
+		}
+		m.re = re
+	}
+
+	return m, nil
+}
+
+func (m Matcher) Matches(labels Labels) bool {
+	v := labels[m.Name]
+
+	switch m.Type {
+	case MatchEqual:
+		return v == m.Value
+	case MatchNotEqual:
+		return v != m.Value
+	case MatchRegexp:
+		return m.re.MatchString(v)
+	case MatchNotRegexp:
+		return !m.re.MatchString(v)
+	}
+
+	return false
+}
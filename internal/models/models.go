@@ -1,8 +1,21 @@
 package models
 
+import "strconv"
+
 type Metrics struct {
-	ID    string   `json:"id"`
-	MType string   `json:"type"`
-	Delta *int64   `json:"delta,omitempty"`
-	Value *float64 `json:"value,omitempty"`
+	ID     string   `json:"id"`
+	MType  string   `json:"type"`
+	Delta  *int64   `json:"delta,omitempty"`
+	Value  *float64 `json:"value,omitempty"`
+	Host   string   `json:"host,omitempty"`
+	Labels Labels   `json:"labels,omitempty"`
+
+	Histogram *Histogram `json:"histogram,omitempty"`
+	Summary   *Summary   `json:"summary,omitempty"`
+}
+
+// Key identifies the series of a metric: its type, host, name and labels.
+// Each of them is quoted, so no two series share a key whatever they hold.
+func (m Metrics) Key() string {
+	return strconv.Quote(m.MType) + "," + strconv.Quote(m.Host) + "," + strconv.Quote(m.ID) + "," + strconv.Quote(m.Labels.String())
 }
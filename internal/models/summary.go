@@ -0,0 +1,171 @@
+package models
+
+import (
+	"errors"
+	"maps"
+	"math"
+	"slices"
+)
+
+var ErrAccuracyMismatch = errors.New("summary relative accuracy does not match")
+
+const DefaultRelativeAccuracy = 0.01
+
+// DefaultQuantiles are reported for summaries in the text exposition.
+var DefaultQuantiles = []float64{0.5, 0.9, 0.95, 0.99}
+
+// Summary is a DDSketch: values are counted in logarithmic bins so that any
+// quantile is answered within the relative accuracy, and two sketches with
+// the same accuracy merge by adding bin counts.
+type Summary struct {
+	RelativeAccuracy float64        `json:"relative_accuracy"`
+	Positive         map[int]uint64 `json:"positive,omitempty"`
+	Negative         map[int]uint64 `json:"negative,omitempty"`
+	Zero             uint64         `json:"zero,omitempty"`
+	Sum              float64        `json:"sum"`
+	Count            uint64         `json:"count"`
+}
+
+func NewSummary(relativeAccuracy float64) *Summary {
+	return &Summary{
+		RelativeAccuracy: relativeAccuracy,
+		Positive:         make(map[int]uint64),
+		Negative:         make(map[int]uint64),
+	}
+}
+
+// Clone returns a copy of s that shares nothing with it.
+func (s *Summary) Clone() *Summary {
+	c := *s
+	c.Positive = maps.Clone(s.Positive)
+	c.Negative = maps.Clone(s.Negative)
+
+	return &c
+}
+
+func (s *Summary) gamma() float64 {
+	return (1 + s.RelativeAccuracy) / (1 - s.RelativeAccuracy)
+}
+
+func (s *Summary) index(v float64) int {
+	return int(math.Ceil(math.Log(v) / math.Log(s.gamma())))
+}
+
+func (s *Summary) bucketValue(i int) float64 {
+	g := s.gamma()
+	return 2 * math.Pow(g, float64(i)) / (g + 1)
+}
+
+func (s *Summary) Observe(v float64) {
+	switch {
+	case v > 0:
+		if s.Positive == nil {
+			s.Positive = make(map[int]uint64)
+		}
+		s.Positive[s.index(v)]++
+	case v < 0:
+		if s.Negative == nil {
+			s.Negative = make(map[int]uint64)
+		}
+		s.Negative[s.index(-v)]++
+	default:
+		s.Zero++
+	}
+
+	s.Sum += v
+	s.Count++
+}
+
+// Merge adds the observations of other, which must have the same accuracy.
+func (s *Summary) Merge(other *Summary) error {
+	if s.RelativeAccuracy != other.RelativeAccuracy {
+		return ErrAccuracyMismatch
+	}
+
+	if s.Positive == nil {
+		s.Positive = make(map[int]uint64)
+	}
+	if s.Negative == nil {
+		s.Negative = make(map[int]uint64)
+	}
+
+	for i, c := range other.Positive {
+		s.Positive[i] += c
+	}
+	for i, c := range other.Negative {
+		s.Negative[i] += c
+	}
+	s.Zero += other.Zero
+	s.Sum += other.Sum
+	s.Count += other.Count
+
+	return nil
+}
+
+// Quantile returns the estimated q-quantile, 0 <= q <= 1, of the observed
+// values or NaN when nothing was observed.
+func (s *Summary) Quantile(q float64) float64 {
+	if s.Count == 0 || q < 0 || q > 1 {
+		return math.NaN()
+	}
+
+	rank := uint64(q * float64(s.Count-1))
+	var seen uint64
+
+	negative := sortedKeys(s.Negative)
+	for i := len(negative) - 1; i >= 0; i-- {
+		seen += s.Negative[negative[i]]
+		if seen > rank {
+			return -s.bucketValue(negative[i])
+		}
+	}
+
+	seen += s.Zero
+	if seen > rank {
+		return 0
+	}
+
+	positive := sortedKeys(s.Positive)
+	for _, i := range positive {
+		seen += s.Positive[i]
+		if seen > rank {
+			return s.bucketValue(i)
+		}
+	}
+
+	if len(positive) == 0 {
+		return 0
+	}
+
+	return s.bucketValue(positive[len(positive)-1])
+}
+
+func (s *Summary) Validate() error {
+	if !(s.RelativeAccuracy > 0 && s.RelativeAccuracy < 1) {
+		return errors.New("summary relative accuracy must be between 0 and 1")
+	}
+
+	total := s.Zero
+	for _, c := range s.Positive {
+		total += c
+	}
+	for _, c := range s.Negative {
+		total += c
+	}
+
+	if total != s.Count {
+		return errors.New("summary count must equal the sum of bin counts")
+	}
+
+	return nil
+}
+
+func sortedKeys(m map[int]uint64) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	return keys
+}
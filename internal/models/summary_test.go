@@ -0,0 +1,116 @@
+package models
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummaryQuantile(t *testing.T) {
+	first := NewSummary(DefaultRelativeAccuracy)
+	second := NewSummary(DefaultRelativeAccuracy)
+
+	for i := 1; i <= 1000; i++ {
+		if i%2 == 0 {
+			first.Observe(float64(i))
+		} else {
+			second.Observe(float64(i))
+		}
+	}
+
+	require.NoError(t, first.Merge(second))
+	require.NoError(t, first.Validate())
+	assert.Equal(t, uint64(1000), first.Count)
+	assert.Equal(t, float64(500500), first.Sum)
+
+	tests := []struct {
+		q    float64
+		want float64
+	}{
+		{q: 0, want: 1},
+		{q: 0.5, want: 500},
+		{q: 0.9, want: 900},
+		{q: 0.99, want: 990},
+		{q: 1, want: 1000},
+	}
+	for _, test := range tests {
+		got := first.Quantile(test.q)
+		assert.LessOrEqual(t, math.Abs(got-test.want)/test.want, DefaultRelativeAccuracy, "q=%v got %v", test.q, got)
+	}
+}
+
+func TestSummaryNegativeAndZero(t *testing.T) {
+	s := NewSummary(DefaultRelativeAccuracy)
+	for _, v := range []float64{-10, -5, 0, 0, 5} {
+		s.Observe(v)
+	}
+
+	assert.InDelta(t, -10, s.Quantile(0), 10*DefaultRelativeAccuracy)
+	assert.Equal(t, float64(0), s.Quantile(0.5))
+	assert.InDelta(t, 5, s.Quantile(1), 5*DefaultRelativeAccuracy)
+	assert.True(t, math.IsNaN(NewSummary(DefaultRelativeAccuracy).Quantile(0.5)))
+}
+
+func TestSummaryMergeAccuracyMismatch(t *testing.T) {
+	assert.ErrorIs(t, NewSummary(0.01).Merge(NewSummary(0.02)), ErrAccuracyMismatch)
+}
+
+func TestHistogramMerge(t *testing.T) {
+	h := NewHistogram([]float64{1, 5})
+	for _, v := range []float64{0.5, 1, 3, 10} {
+		h.Observe(v)
+	}
+	assert.Equal(t, []uint64{2, 1, 1}, h.Counts)
+
+	other := NewHistogram([]float64{1, 5})
+	other.Observe(4)
+	require.NoError(t, h.Merge(other))
+	require.NoError(t, h.Validate())
+	assert.Equal(t, []uint64{2, 2, 1}, h.Counts)
+	assert.Equal(t, uint64(5), h.Count)
+	assert.Equal(t, 18.5, h.Sum)
+
+	assert.ErrorIs(t, h.Merge(NewHistogram([]float64{1, 10})), ErrBucketsMismatch)
+}
+
+func TestHistogramMergeMismatchedCounts(t *testing.T) {
+	tests := []struct {
+		name  string
+		h     *Histogram
+		other *Histogram
+	}{
+		{
+			name:  "other has fewer counts",
+			h:     NewHistogram([]float64{1, 5}),
+			other: &Histogram{Buckets: []float64{1, 5}, Counts: []uint64{1}},
+		},
+		{
+			name:  "other has more counts",
+			h:     NewHistogram([]float64{1, 5}),
+			other: &Histogram{Buckets: []float64{1, 5}, Counts: []uint64{1, 1, 1, 1}},
+		},
+		{
+			name:  "both have too few counts",
+			h:     &Histogram{Buckets: []float64{1, 5}, Counts: []uint64{1}},
+			other: &Histogram{Buckets: []float64{1, 5}, Counts: []uint64{1}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := tt.h.Clone()
+			assert.ErrorIs(t, tt.h.Merge(tt.other), ErrBucketsMismatch)
+			assert.Equal(t, before, tt.h)
+		})
+	}
+}
+
+func TestHistogramValidateSum(t *testing.T) {
+	for _, sum := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		h := NewHistogram([]float64{1, 5})
+		h.Sum = sum
+		assert.Error(t, h.Validate(), "sum %v", sum)
+	}
+}
@@ -0,0 +1,224 @@
+// Package retry calls operations again when they fail for a reason that may
+// go away, waiting an exponentially growing, fully jittered interval between
+// attempts. An optional circuit breaker shared by the callers of a dependency
+// stops them from retrying against it while it is down.
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Policy says how often and how long to retry. The zero value retries with
+// DefaultPolicy's intervals, without a time limit and without a breaker.
+type Policy struct {
+	// InitialInterval caps the wait before the second attempt. Every next
+	// cap is Multiplier times bigger, up to MaxInterval. The actual wait is
+	// a random duration up to the cap.
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	Multiplier      float64
+	// MaxElapsedTime stops retrying once the next attempt would start
+	// later than this after the first one. Zero means no limit.
+	MaxElapsedTime time.Duration
+	// Retryable reports whether an error is worth retrying, Retryable by
+	// default.
+	Retryable func(err error) bool
+	// Breaker, if set, is told about every attempt and may refuse them.
+	Breaker *Breaker
+	// OnRetry, if set, is called before waiting to retry after err.
+	OnRetry func(attempt int, err error, wait time.Duration)
+}
+
+var DefaultPolicy = Policy{
+	InitialInterval: 500 * time.Millisecond,
+	MaxInterval:     5 * time.Second,
+	Multiplier:      2,
+	MaxElapsedTime:  15 * time.Second,
+}
+
+// Do calls fn until it succeeds, fails with an error that is not retryable,
+// the policy gives up or ctx is done. It returns the last error of fn, or an
+// error wrapping ErrOpen if the breaker refused an attempt.
+func (p Policy) Do(ctx context.Context, fn func() error) error {
+	started := time.Now()
+
+	var lastErr error
+	for attempt := 0; ; attempt++ {
+		if p.Breaker != nil {
+			if err := p.Breaker.Allow(); err != nil {
+				if lastErr != nil {
+					return fmt.Errorf("%w: %w", err, lastErr)
+				}
+				return err
+			}
+		}
+
+		err := fn()
+		retryable := err != nil && p.retryable(err)
+
+		if p.Breaker != nil {
+			if retryable {
+				p.Breaker.Failure()
+			} else {
+				p.Breaker.Success()
+			}
+		}
+
+		if !retryable {
+			var permanent *permanentError
+			if errors.As(err, &permanent) {
+				return permanent.err
+			}
+			return err
+		}
+		lastErr = err
+
+		wait := p.backoff(attempt)
+
+		var httpErr *HTTPError
+		if errors.As(err, &httpErr) && httpErr.RetryAfter > wait {
+			wait = httpErr.RetryAfter
+		}
+
+		if p.MaxElapsedTime > 0 && time.Since(started)+wait > p.MaxElapsedTime {
+			return err
+		}
+
+		if p.OnRetry != nil {
+			p.OnRetry(attempt, err, wait)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff returns a random wait before the attempt after the given one.
+func (p Policy) backoff(attempt int) time.Duration {
+	initial := p.InitialInterval
+	if initial <= 0 {
+		initial = DefaultPolicy.InitialInterval
+	}
+
+	maxInterval := p.MaxInterval
+	if maxInterval <= 0 {
+		maxInterval = DefaultPolicy.MaxInterval
+	}
+
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = DefaultPolicy.Multiplier
+	}
+
+	ceiling := float64(initial) * math.Pow(multiplier, float64(attempt))
+	if ceiling > float64(maxInterval) {
+		ceiling = float64(maxInterval)
+	}
+
+	return time.Duration(rand.Int64N(int64(ceiling) + 1))
+}
+
+func (p Policy) retryable(err error) bool {
+	var permanent *permanentError
+	if errors.As(err, &permanent) {
+		return false
+	}
+
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+
+	return Retryable(err)
+}
+
+// Retryable reports whether err is a network error or an HTTPError with a
+// status worth retrying. Context errors and anything else are not retried.
+func Retryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode >= http.StatusInternalServerError || httpErr.StatusCode == http.StatusTooManyRequests
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks err as not worth retrying whatever its kind.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &permanentError{err: err}
+}
+
+// HTTPError is an unexpected response status. RetryAfter is the wait the
+// server asked for in its Retry-After header, if it did.
+type HTTPError struct {
+	StatusCode int
+	Status     string
+	RetryAfter time.Duration
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("server responded %s", e.Status)
+}
+
+// NewHTTPError returns the error of a response with the given status and
+// headers.
+func NewHTTPError(statusCode int, status string, header http.Header) *HTTPError {
+	return &HTTPError{
+		StatusCode: statusCode,
+		Status:     status,
+		RetryAfter: parseRetryAfter(header.Get("Retry-After")),
+	}
+}
+
+// parseRetryAfter reads a Retry-After value given either in seconds or as a
+// date.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.ParseUint(value, 10, 32); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+	}
+
+	return 0
+}
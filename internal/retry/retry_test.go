@@ -0,0 +1,225 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var fast = Policy{
+	InitialInterval: time.Millisecond,
+	MaxInterval:     5 * time.Millisecond,
+	Multiplier:      2,
+	MaxElapsedTime:  time.Second,
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "Test network error",
+			err:  &net.OpError{Op: "dial", Err: errors.New("connection refused")},
+			want: true,
+		},
+		{
+			name: "Test server error",
+			err:  &HTTPError{StatusCode: http.StatusBadGateway},
+			want: true,
+		},
+		{
+			name: "Test too many requests",
+			err:  &HTTPError{StatusCode: http.StatusTooManyRequests},
+			want: true,
+		},
+		{
+			name: "Test bad request",
+			err:  &HTTPError{StatusCode: http.StatusBadRequest},
+			want: false,
+		},
+		{
+			name: "Test cancelled",
+			err:  context.Canceled,
+			want: false,
+		},
+		{
+			name: "Test other error",
+			err:  errors.New("cannot encode"),
+			want: false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, Retryable(test.err))
+		})
+	}
+}
+
+func TestDo(t *testing.T) {
+	serverErr := &HTTPError{StatusCode: http.StatusServiceUnavailable, Status: "503 Service Unavailable"}
+	badRequest := &HTTPError{StatusCode: http.StatusBadRequest, Status: "400 Bad Request"}
+
+	tests := []struct {
+		name     string
+		errs     []error
+		want     error
+		attempts int
+	}{
+		{
+			name:     "Test success",
+			errs:     []error{nil},
+			attempts: 1,
+		},
+		{
+			name:     "Test success after retries",
+			errs:     []error{serverErr, serverErr, nil},
+			attempts: 3,
+		},
+		{
+			name:     "Test not retryable",
+			errs:     []error{badRequest},
+			want:     badRequest,
+			attempts: 1,
+		},
+		{
+			name:     "Test permanent",
+			errs:     []error{Permanent(serverErr)},
+			want:     serverErr,
+			attempts: 1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			attempts := 0
+			err := fast.Do(context.Background(), func() error {
+				err := test.errs[attempts]
+				attempts++
+				return err
+			})
+
+			assert.Equal(t, test.want, err)
+			assert.Equal(t, test.attempts, attempts)
+		})
+	}
+}
+
+func TestDoOnRetry(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Err: errors.New("connection refused")}
+
+	var retried []int
+	policy := fast
+	policy.OnRetry = func(attempt int, err error, _ time.Duration) {
+		assert.Equal(t, refused, err)
+		retried = append(retried, attempt)
+	}
+
+	errs := []error{refused, refused, nil}
+	attempts := 0
+	require.NoError(t, policy.Do(context.Background(), func() error {
+		err := errs[attempts]
+		attempts++
+		return err
+	}))
+
+	assert.Equal(t, []int{0, 1}, retried)
+}
+
+func TestDoMaxElapsedTime(t *testing.T) {
+	policy := fast
+	policy.MaxElapsedTime = 50 * time.Millisecond
+
+	started := time.Now()
+	err := policy.Do(context.Background(), func() error {
+		return &net.OpError{Op: "dial", Err: errors.New("connection refused")}
+	})
+
+	require.Error(t, err)
+	assert.Less(t, time.Since(started), 50*time.Millisecond+10*time.Millisecond)
+}
+
+func TestDoRetryAfter(t *testing.T) {
+	header := http.Header{}
+	header.Set("Retry-After", "1")
+	throttled := NewHTTPError(http.StatusTooManyRequests, "429 Too Many Requests", header)
+	assert.Equal(t, time.Second, throttled.RetryAfter)
+
+	policy := fast
+	policy.MaxElapsedTime = 500 * time.Millisecond
+
+	// Waiting as asked would take longer than the policy allows.
+	attempts := 0
+	err := policy.Do(context.Background(), func() error {
+		attempts++
+		return throttled
+	})
+
+	assert.Equal(t, throttled, err)
+	assert.Equal(t, 1, attempts)
+}
+
+func TestBackoffJitter(t *testing.T) {
+	policy := Policy{InitialInterval: 10 * time.Millisecond, MaxInterval: 40 * time.Millisecond, Multiplier: 2}
+
+	for attempt, ceiling := range []time.Duration{10, 20, 40, 40} {
+		for i := 0; i < 100; i++ {
+			wait := policy.backoff(attempt)
+			assert.GreaterOrEqual(t, wait, time.Duration(0))
+			assert.LessOrEqual(t, wait, ceiling*time.Millisecond)
+		}
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	now := time.Now()
+	b := NewBreaker(2, time.Minute)
+	b.now = func() time.Time { return now }
+
+	policy := fast
+	policy.Breaker = b
+
+	down := &net.OpError{Op: "dial", Err: errors.New("connection refused")}
+
+	attempts := 0
+	err := policy.Do(context.Background(), func() error {
+		attempts++
+		return down
+	})
+	assert.ErrorIs(t, err, ErrOpen)
+	assert.ErrorIs(t, err, down)
+	assert.Equal(t, 2, attempts)
+	assert.Equal(t, Open, b.State())
+
+	// An open breaker fails fast.
+	err = policy.Do(context.Background(), func() error {
+		attempts++
+		return nil
+	})
+	assert.ErrorIs(t, err, ErrOpen)
+	assert.Equal(t, 2, attempts)
+
+	// After the cooldown a single probe goes through.
+	now = now.Add(time.Minute)
+	require.NoError(t, b.Allow())
+	assert.Equal(t, HalfOpen, b.State())
+	assert.ErrorIs(t, b.Allow(), ErrOpen)
+
+	b.Failure()
+	assert.Equal(t, Open, b.State())
+
+	now = now.Add(time.Minute)
+	err = policy.Do(context.Background(), func() error {
+		attempts++
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 3, attempts)
+	assert.Equal(t, Closed, b.State())
+}
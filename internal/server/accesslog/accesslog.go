@@ -0,0 +1,101 @@
+// Package accesslog logs the requests served by the server.
+package accesslog
+
+import (
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/lambawebdev/metrics/internal/logger"
+	"github.com/lambawebdev/metrics/internal/server/response"
+	"github.com/lambawebdev/metrics/internal/server/tracing"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// AgentIDHeader names the agent that sent a request.
+const AgentIDHeader = "X-Agent-ID"
+
+// sample2xx logs one of every sample2xx successful requests, none if zero.
+// seen2xx counts them.
+var (
+	sample2xx atomic.Uint64
+	seen2xx   atomic.Uint64
+)
+
+func init() {
+	sample2xx.Store(1)
+}
+
+type countingReader struct {
+	io.ReadCloser
+	size int
+}
+
+func (r *countingReader) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	r.size += n
+	return n, err
+}
+
+// SetSampling makes the access log keep one of every n successful
+// requests, or none if n is zero. Failed requests are always logged.
+func SetSampling(n uint64) {
+	sample2xx.Store(n)
+}
+
+// accessLevel picks the level of the access log entry of a request with the
+// given status, and whether to log it at all.
+func accessLevel(status int) (zapcore.Level, bool) {
+	switch {
+	case status >= http.StatusInternalServerError:
+		return zapcore.ErrorLevel, true
+	case status >= http.StatusBadRequest:
+		return zapcore.WarnLevel, true
+	case status >= http.StatusOK && status < http.StatusMultipleChoices:
+		n := sample2xx.Load()
+		return zapcore.InfoLevel, n != 0 && (seen2xx.Add(1)-1)%n == 0
+	default:
+		return zapcore.InfoLevel, true
+	}
+}
+
+// WithLoggingMiddleware writes one access log entry per request, once it is
+// served. Sizes are of the bodies as sent, so compressed if they were.
+func WithLoggingMiddleware(h http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+
+		body := &countingReader{ReadCloser: r.Body}
+		r.Body = body
+
+		result := response.Serve(h, w, r)
+
+		lvl, ok := accessLevel(result.Status)
+		if !ok {
+			return
+		}
+
+		entry := logger.Log.Check(lvl, "HTTP request")
+		if entry == nil {
+			return
+		}
+
+		fields := []zap.Field{
+			zap.String("method", r.Method),
+			zap.String("path", r.URL.Path),
+			zap.String("route", result.Route),
+			zap.Int("status", result.Status),
+			zap.String("status_text", http.StatusText(result.Status)),
+			zap.Duration("latency", time.Since(started)),
+			zap.Int("bytes_in", body.size),
+			zap.Int("bytes_out", result.Size),
+			zap.String("remote_addr", r.RemoteAddr),
+			zap.String("user_agent", r.UserAgent()),
+			zap.String("agent_id", r.Header.Get(AgentIDHeader)),
+		}
+
+		entry.Write(append(fields, tracing.LogFields(r.Context())...)...)
+	})
+}
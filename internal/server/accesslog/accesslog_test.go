@@ -0,0 +1,106 @@
+package accesslog
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lambawebdev/metrics/internal/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func observe(t *testing.T) *observer.ObservedLogs {
+	core, logs := observer.New(zapcore.DebugLevel)
+
+	previous := logger.Log
+	logger.Log = zap.New(core)
+	t.Cleanup(func() {
+		logger.Log = previous
+		SetSampling(1)
+	})
+
+	return logs
+}
+
+func TestWithLoggingMiddleware(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		wantLevel zapcore.Level
+	}{
+		{name: "Test ok", status: http.StatusOK, wantLevel: zapcore.InfoLevel},
+		{name: "Test client error", status: http.StatusBadRequest, wantLevel: zapcore.WarnLevel},
+		{name: "Test server error", status: http.StatusInternalServerError, wantLevel: zapcore.ErrorLevel},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logs := observe(t)
+
+			r := chi.NewRouter()
+			r.Post("/update/{type}/{name}/{value}", WithLoggingMiddleware(func(w http.ResponseWriter, r *http.Request) {
+				io.ReadAll(r.Body)
+				w.WriteHeader(test.status)
+				io.WriteString(w, "done")
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/update/gauge/Alloc/1", strings.NewReader("payload"))
+			req.Header.Set(AgentIDHeader, "web-1")
+			req.Header.Set("User-Agent", "agent/1.0")
+			r.ServeHTTP(httptest.NewRecorder(), req)
+
+			entries := logs.All()
+			require.Len(t, entries, 1)
+			assert.Equal(t, test.wantLevel, entries[0].Level)
+
+			fields := entries[0].ContextMap()
+			assert.Equal(t, "/update/{type}/{name}/{value}", fields["route"])
+			assert.Equal(t, "/update/gauge/Alloc/1", fields["path"])
+			assert.Equal(t, int64(test.status), fields["status"])
+			assert.Equal(t, http.StatusText(test.status), fields["status_text"])
+			assert.Equal(t, int64(len("payload")), fields["bytes_in"])
+			assert.Equal(t, int64(len("done")), fields["bytes_out"])
+			assert.Equal(t, "web-1", fields["agent_id"])
+			assert.Equal(t, "agent/1.0", fields["user_agent"])
+			assert.Contains(t, fields, "latency")
+			assert.Contains(t, fields, "remote_addr")
+		})
+	}
+}
+
+func TestWithLoggingMiddlewareSampling(t *testing.T) {
+	tests := []struct {
+		name   string
+		sample uint64
+		status int
+		want   int
+	}{
+		{name: "Test all successes", sample: 1, status: http.StatusOK, want: 6},
+		{name: "Test every third success", sample: 3, status: http.StatusOK, want: 2},
+		{name: "Test no successes", sample: 0, status: http.StatusOK, want: 0},
+		{name: "Test failures never sampled", sample: 0, status: http.StatusNotFound, want: 6},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logs := observe(t)
+			SetSampling(test.sample)
+			seen2xx.Store(0)
+
+			h := WithLoggingMiddleware(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(test.status)
+			})
+
+			for i := 0; i < 6; i++ {
+				h(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ping", nil))
+			}
+
+			assert.Equal(t, test.want, logs.Len())
+		})
+	}
+}
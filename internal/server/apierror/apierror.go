@@ -0,0 +1,55 @@
+// Package apierror writes the error responses of the server API. Every error
+// has the same JSON envelope:
+//
+//	{"error": {"code": "metric_not_found", "message": "Metric not exists!", "details": {...}}}
+//
+// Codes are stable, so clients can act on them, while messages may change.
+package apierror
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Codes of the errors the API returns.
+const (
+	CodeInvalidBody           = "invalid_body"
+	CodeBodyTooLarge          = "body_too_large"
+	CodeHashMismatch          = "hash_mismatch"
+	CodeMetricTypeUnsupported = "metric_type_unsupported"
+	CodeMetricValueInvalid    = "metric_value_invalid"
+	CodeMetricValueMissing    = "metric_value_missing"
+	CodeMetricNotFound        = "metric_not_found"
+	CodeLabelsInvalid         = "labels_invalid"
+	CodeMatcherInvalid        = "label_matcher_invalid"
+	CodeDistributionInvalid   = "distribution_invalid"
+	CodeIdempotencyKeyInvalid = "idempotency_key_invalid"
+	CodeConfigInvalid         = "config_invalid"
+	CodeImportModeInvalid     = "import_mode_invalid"
+	CodeUnauthorized          = "unauthorized"
+	CodeNotFound              = "not_found"
+	CodeUnavailable           = "unavailable"
+	CodeInternal              = "internal_error"
+)
+
+// Body is the JSON body of an error response.
+type Body struct {
+	Error Error `json:"error"`
+}
+
+// Error describes a failed request. Message is meant for people and Details
+// holds what the error is about.
+type Error struct {
+	Code    string                 `json:"code"`
+	Message string                 `json:"message"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// Write sends an error response. Nothing may be written to res before.
+func Write(res http.ResponseWriter, status int, code string, message string, details map[string]interface{}) {
+	res.Header().Set("Content-Type", "application/json")
+	res.Header().Set("X-Content-Type-Options", "nosniff")
+	res.WriteHeader(status)
+
+	json.NewEncoder(res).Encode(Body{Error: Error{Code: code, Message: message, Details: details}})
+}
@@ -1,85 +1,147 @@
 package config
 
 import (
-	"flag"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
 	"os"
-	"strconv"
+	"time"
+
+	"github.com/lambawebdev/metrics/internal/configload"
+	"github.com/lambawebdev/metrics/internal/validators"
+	"go.uber.org/zap/zapcore"
 )
 
-var options struct {
-	flagRunAddr          string
-	storeIntervalSeconds uint64
-	fileStoragePath      string
-	restoreMetrics       bool
-	databaseDsn          string
-	secretKey            string
+// MaxStoredNameLength is the size of the name column in Postgres.
+const MaxStoredNameLength = 30
+
+// MaxStoredHostLength is the size of the host column in Postgres.
+const MaxStoredHostLength = 255
+
+// Config is the server configuration. See package configload for where the
+// values come from.
+type Config struct {
+	Address                  string   `json:"address" yaml:"address"`
+	StoreIntervalSeconds     uint64   `json:"store_interval" yaml:"store_interval"`
+	FileStoragePath          string   `json:"file_storage_path" yaml:"file_storage_path"`
+	Restore                  bool     `json:"restore" yaml:"restore"`
+	DatabaseDSN              string   `json:"database_dsn" yaml:"database_dsn"`
+	Key                      string   `json:"key" yaml:"key"`
+	MaxLabels                uint64   `json:"max_labels" yaml:"max_labels"`
+	MaxSeriesPerMetric       uint64   `json:"max_series_per_metric" yaml:"max_series_per_metric"`
+	IdempotencyWindowSeconds uint64   `json:"idempotency_window" yaml:"idempotency_window"`
+	MaxSeries                uint64   `json:"max_series" yaml:"max_series"`
+	MetricNamePattern        string   `json:"metric_name_pattern" yaml:"metric_name_pattern"`
+	MaxMetricNameLength      uint64   `json:"max_metric_name_length" yaml:"max_metric_name_length"`
+	MetricNameAllow          []string `json:"metric_name_allow" yaml:"metric_name_allow"`
+	MetricNameDeny           []string `json:"metric_name_deny" yaml:"metric_name_deny"`
+	TraceEndpoint            string   `json:"trace_endpoint" yaml:"trace_endpoint"`
+	LogLevel                 string   `json:"log_level" yaml:"log_level"`
+	AccessLogSample          uint64   `json:"access_log_sample" yaml:"access_log_sample"`
+	AdminToken               string   `json:"admin_token" yaml:"admin_token"`
 }
 
-func ParseFlags() {
-	flag.StringVar(&options.flagRunAddr, "a", "localhost:8080", "address and port to run server")
-	flag.Uint64Var(&options.storeIntervalSeconds, "i", 300, "save metrics after interval seconds")
-	flag.StringVar(&options.fileStoragePath, "f", "/tmp/storage", "file storage path")
-	flag.BoolVar(&options.restoreMetrics, "r", true, "if true - metrics will be loaded from file")
-	flag.StringVar(&options.databaseDsn, "d", "host=localhost user=test password=password dbname=videos sslmode=disable", "pgsql data source name")
-	flag.StringVar(&options.secretKey, "k", "", "set secret key")
+// Default returns the configuration used for everything not set otherwise.
+func Default() *Config {
+	return &Config{
+		Address:                  "localhost:8080",
+		StoreIntervalSeconds:     300,
+		FileStoragePath:          "/tmp/storage",
+		Restore:                  true,
+		MaxLabels:                16,
+		MaxSeriesPerMetric:       1000,
+		IdempotencyWindowSeconds: 300,
+		MaxSeries:                100000,
+		MetricNamePattern:        `^[a-zA-Z_:][a-zA-Z0-9_:]*$`,
+		MaxMetricNameLength:      MaxStoredNameLength,
+		LogLevel:                 "info",
+		AccessLogSample:          1,
+	}
+}
 
-	flag.Parse()
+// New loads the configuration from the command line arguments, without the
+// program name, the environment and the config file they name.
+func New(args []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	cfg := Default()
 
-	if envRunAddr := os.Getenv("ADDRESS"); envRunAddr != "" {
-		options.flagRunAddr = envRunAddr
+	if err := configload.Load(os.Args[0], args, lookupEnv, cfg, cfg.options()); err != nil {
+		return nil, err
 	}
 
-	if storeIntervalSeconds := os.Getenv("STORE_INTERVAL"); storeIntervalSeconds != "" {
-		value, err := strconv.ParseUint(storeIntervalSeconds, 10, 64)
-		if err == nil {
-			options.storeIntervalSeconds = value
-		}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
 	}
 
-	if fileStoragePath := os.Getenv("FILE_STORAGE_PATH"); fileStoragePath != "" {
-		options.fileStoragePath = fileStoragePath
+	return cfg, nil
+}
+
+func (c *Config) options() []configload.Option {
+	return []configload.Option{
+		{Flag: "a", Env: "ADDRESS", Usage: "address and port to run server", Value: &c.Address},
+		{Flag: "i", Env: "STORE_INTERVAL", Usage: "save metrics after interval seconds", Value: &c.StoreIntervalSeconds},
+		{Flag: "f", Env: "FILE_STORAGE_PATH", Usage: "file storage path", Value: &c.FileStoragePath},
+		{Flag: "r", Env: "RESTORE", Usage: "if true - metrics will be loaded from file", Value: &c.Restore},
+		{Flag: "d", Env: "DATABASE_DSN", Usage: "pgsql data source name, metrics are kept in memory if empty", Value: &c.DatabaseDSN},
+		{Flag: "k", Env: "KEY", Usage: "set secret key", Value: &c.Key},
+		{Flag: "max-labels", Env: "MAX_LABELS", Usage: "max labels per metric, 0 is unlimited", Value: &c.MaxLabels},
+		{Flag: "max-series-per-metric", Env: "MAX_SERIES_PER_METRIC", Usage: "max label sets per metric name, 0 is unlimited", Value: &c.MaxSeriesPerMetric},
+		{Flag: "max-series", Env: "MAX_SERIES", Usage: "max series stored in total, 0 is unlimited", Value: &c.MaxSeries},
+		{Flag: "metric-name-pattern", Env: "METRIC_NAME_PATTERN", Usage: "regexp metric names have to match", Value: &c.MetricNamePattern},
+		{Flag: "max-metric-name-length", Env: "MAX_METRIC_NAME_LENGTH", Usage: "max metric name length", Value: &c.MaxMetricNameLength},
+		{Flag: "metric-name-allow", Env: "METRIC_NAME_ALLOW", Usage: "comma separated regexps of the only metric names stored, all if empty", Value: &c.MetricNameAllow},
+		{Flag: "metric-name-deny", Env: "METRIC_NAME_DENY", Usage: "comma separated regexps of metric names never stored", Value: &c.MetricNameDeny},
+		{Flag: "trace-endpoint", Env: "TRACE_ENDPOINT", Usage: "OTLP/HTTP collector URL to export traces to, such as http://localhost:4318, none if empty", Value: &c.TraceEndpoint},
+		{Flag: "log-level", Env: "LOG_LEVEL", Usage: "log level: debug, info, warn or error", Value: &c.LogLevel},
+		{Flag: "access-log-sample", Env: "ACCESS_LOG_SAMPLE", Usage: "log one of every n successful requests, none if 0, failed ones are always logged", Value: &c.AccessLogSample},
+		{Flag: "admin-token", Env: "ADMIN_TOKEN", Usage: "bearer token for the admin endpoints, they are disabled if empty", Value: &c.AdminToken},
+		{Flag: "idempotency-window", Env: "IDEMPOTENCY_WINDOW", Usage: "seconds to remember applied batch idempotency keys", Value: &c.IdempotencyWindowSeconds},
 	}
+}
 
-	if restoreMetrics := os.Getenv("RESTORE"); restoreMetrics != "" {
-		value, err := strconv.ParseBool(restoreMetrics)
-		if err == nil {
-			options.restoreMetrics = value
-		}
+// Validate reports every setting the server cannot run with.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if _, _, err := net.SplitHostPort(c.Address); err != nil {
+		errs = append(errs, fmt.Errorf("address: %w", err))
 	}
 
-	if databaseDsn := os.Getenv("DATABASE_DSN"); databaseDsn != "" {
-		options.databaseDsn = databaseDsn
+	if c.FileStoragePath == "" && c.DatabaseDSN == "" {
+		errs = append(errs, errors.New("file_storage_path: must be set when database_dsn is not"))
 	}
 
-	if secretKey := os.Getenv("KEY"); secretKey != "" {
-		options.secretKey = secretKey
+	if c.StoreIntervalSeconds == 0 {
+		errs = append(errs, errors.New("store_interval: must be positive"))
 	}
-}
 
-func GetFlagRunAddr() string {
-	return options.flagRunAddr
-}
+	if c.MaxMetricNameLength == 0 || (c.DatabaseDSN != "" && c.MaxMetricNameLength > MaxStoredNameLength) {
+		errs = append(errs, fmt.Errorf("max_metric_name_length: must be between 1 and %d", MaxStoredNameLength))
+	}
 
-func GetStoreIntervalSeconds() uint64 {
-	return options.storeIntervalSeconds
-}
+	if _, err := c.NamePolicy(); err != nil {
+		errs = append(errs, fmt.Errorf("metric name policy: %w", err))
+	}
 
-func GetFileStoragePath() string {
-	return options.fileStoragePath
-}
+	if c.TraceEndpoint != "" {
+		if u, err := url.Parse(c.TraceEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, errors.New("trace_endpoint: must be an http or https URL"))
+		}
+	}
 
-func GetRestoreMetrics() bool {
-	return options.restoreMetrics
-}
+	if _, err := zapcore.ParseLevel(c.LogLevel); err != nil {
+		errs = append(errs, fmt.Errorf("log_level: %w", err))
+	}
 
-func SetRestoreMetrics(bool bool) {
-	options.restoreMetrics = bool
+	return errors.Join(errs...)
 }
 
-func GetDatabaseDsn() string {
-	return options.databaseDsn
+// NamePolicy returns the policy for the metric names the server stores.
+func (c *Config) NamePolicy() (*validators.NamePolicy, error) {
+	return validators.NewNamePolicy(c.MetricNamePattern, int(c.MaxMetricNameLength), c.MetricNameAllow, c.MetricNameDeny)
 }
 
-func GetSecretKey() string {
-	return options.secretKey
+// IdempotencyWindow is how long applied batch idempotency keys are kept.
+func (c *Config) IdempotencyWindow() time.Duration {
+	return time.Duration(c.IdempotencyWindowSeconds) * time.Second
 }
@@ -0,0 +1,91 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func withDefaults(modify func(c *Config)) *Config {
+	c := Default()
+	modify(c)
+	return c
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		want    *Config
+		wantErr bool
+	}{
+		{
+			name: "Test defaults",
+			want: Default(),
+		},
+		{
+			name: "Test database without file",
+			args: []string{"-d", "postgres://localhost/metrics", "-f", ""},
+			want: withDefaults(func(c *Config) {
+				c.DatabaseDSN = "postgres://localhost/metrics"
+				c.FileStoragePath = ""
+			}),
+		},
+		{
+			name:    "Test invalid store interval",
+			env:     map[string]string{"STORE_INTERVAL": "abc"},
+			wantErr: true,
+		},
+		{
+			name:    "Test invalid address",
+			args:    []string{"-a", "localhost"},
+			wantErr: true,
+		},
+		{
+			name:    "Test invalid log level",
+			env:     map[string]string{"LOG_LEVEL": "loud"},
+			wantErr: true,
+		},
+		{
+			name: "Test name lists",
+			env:  map[string]string{"METRIC_NAME_DENY": "Debug.*,Tmp.*"},
+			want: withDefaults(func(c *Config) {
+				c.MetricNameDeny = []string{"Debug.*", "Tmp.*"}
+			}),
+		},
+		{
+			name:    "Test invalid name pattern",
+			env:     map[string]string{"METRIC_NAME_ALLOW": "(Cpu"},
+			wantErr: true,
+		},
+		{
+			name:    "Test name longer than the column",
+			args:    []string{"-d", "postgres://localhost/metrics", "-max-metric-name-length", "64"},
+			wantErr: true,
+		},
+		{
+			name:    "Test no storage",
+			args:    []string{"-f", ""},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lookupEnv := func(key string) (string, bool) {
+				value, ok := test.env[key]
+				return value, ok
+			}
+
+			cfg, err := New(test.args, lookupEnv)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, test.want, cfg)
+		})
+	}
+}
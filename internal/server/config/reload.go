@@ -0,0 +1,83 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// Reloader holds the running configuration and replaces it with a fresh
+// load on request. A config that fails to load or changes a setting the
+// server only reads at startup is rejected, and the running one is kept.
+type Reloader struct {
+	mu       sync.Mutex
+	current  atomic.Pointer[Config]
+	load     func() (*Config, error)
+	onReload []func(cfg *Config)
+}
+
+// NewReloader starts with cfg and reloads with load.
+func NewReloader(cfg *Config, load func() (*Config, error)) *Reloader {
+	r := &Reloader{load: load}
+	r.current.Store(cfg)
+
+	return r
+}
+
+// Current returns the running configuration. It must not be modified.
+func (r *Reloader) Current() *Config {
+	return r.current.Load()
+}
+
+// OnReload registers fn to apply every configuration that replaces the
+// running one.
+func (r *Reloader) OnReload(fn func(cfg *Config)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.onReload = append(r.onReload, fn)
+}
+
+// Reload loads the configuration again and swaps it in.
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cfg, err := r.load()
+	if err != nil {
+		return err
+	}
+
+	if err := restartOnly(r.current.Load(), cfg); err != nil {
+		return err
+	}
+
+	r.current.Store(cfg)
+	for _, fn := range r.onReload {
+		fn(cfg)
+	}
+
+	return nil
+}
+
+// restartOnly reports the settings that differ but cannot change while the
+// server runs.
+func restartOnly(old, cfg *Config) error {
+	var errs []error
+
+	changed := func(name string, differ bool) {
+		if differ {
+			errs = append(errs, fmt.Errorf("%s: cannot change without a restart", name))
+		}
+	}
+
+	changed("address", old.Address != cfg.Address)
+	changed("file_storage_path", old.FileStoragePath != cfg.FileStoragePath)
+	changed("restore", old.Restore != cfg.Restore)
+	changed("database_dsn", old.DatabaseDSN != cfg.DatabaseDSN)
+	changed("trace_endpoint", old.TraceEndpoint != cfg.TraceEndpoint)
+	changed("idempotency_window", old.IdempotencyWindowSeconds != cfg.IdempotencyWindowSeconds)
+
+	return errors.Join(errs...)
+}
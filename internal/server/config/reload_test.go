@@ -0,0 +1,64 @@
+package config
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReload(t *testing.T) {
+	changed := Default()
+	changed.LogLevel = "debug"
+	changed.StoreIntervalSeconds = 10
+
+	moved := Default()
+	moved.Address = "localhost:9090"
+
+	tests := []struct {
+		name    string
+		load    func() (*Config, error)
+		want    *Config
+		wantErr bool
+	}{
+		{
+			name: "Test reload swaps config",
+			load: func() (*Config, error) { return changed, nil },
+			want: changed,
+		},
+		{
+			name:    "Test invalid config kept out",
+			load:    func() (*Config, error) { return nil, errors.New("store_interval: must be positive") },
+			wantErr: true,
+		},
+		{
+			name:    "Test restart only setting kept out",
+			load:    func() (*Config, error) { return moved, nil },
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			running := Default()
+			r := NewReloader(running, test.load)
+
+			var applied []*Config
+			r.OnReload(func(cfg *Config) {
+				applied = append(applied, cfg)
+			})
+
+			err := r.Reload()
+			if test.wantErr {
+				assert.Error(t, err)
+				assert.Same(t, running, r.Current())
+				assert.Empty(t, applied)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Same(t, test.want, r.Current())
+			assert.Equal(t, []*Config{test.want}, applied)
+		})
+	}
+}
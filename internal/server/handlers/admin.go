@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/lambawebdev/metrics/internal/models"
+	"github.com/lambawebdev/metrics/internal/server/apierror"
+	"github.com/lambawebdev/metrics/internal/server/config"
+	"github.com/lambawebdev/metrics/internal/server/storage"
+	"github.com/lambawebdev/metrics/internal/validators"
+)
+
+// ndjsonContentType is the content type of dumps with one metric per line.
+const ndjsonContentType = "application/x-ndjson"
+
+// maxImportBytes bounds the dump an import reads, once decompressed, as the
+// whole of it is held in memory until it is stored. Tests lower it.
+var maxImportBytes int64 = 64 << 20
+
+// Modes of an import.
+const (
+	importMerge   = "merge"
+	importReplace = "replace"
+)
+
+// AdminHandler serves the endpoints that operate the server itself.
+type AdminHandler struct {
+	reloader *config.Reloader
+	storage  storage.MetricStorage
+}
+
+func NewAdminHandler(reloader *config.Reloader, storage storage.MetricStorage) *AdminHandler {
+	return &AdminHandler{
+		reloader: reloader,
+		storage:  storage,
+	}
+}
+
+// Reload reloads the configuration. An invalid one is reported and the
+// running one kept.
+func (ah *AdminHandler) Reload(res http.ResponseWriter, _ *http.Request) {
+	if err := ah.reloader.Reload(); err != nil {
+		apierror.Write(res, http.StatusUnprocessableEntity, apierror.CodeConfigInvalid, err.Error(), nil)
+		return
+	}
+
+	res.WriteHeader(http.StatusOK)
+}
+
+// Export dumps every stored metric as a JSON array, or one JSON object per
+// line if ?format=ndjson or Accept asks for NDJSON. The dump is what Import
+// takes, whichever storage it came from. Metrics are written as the storage
+// reads them, so the status is only sent with the first one: an export
+// failing later is cut short, and a JSON array left unclosed.
+func (ah *AdminHandler) Export(res http.ResponseWriter, req *http.Request) {
+	ndjson := req.URL.Query().Get("format") == "ndjson" || strings.Contains(req.Header.Get("Accept"), ndjsonContentType)
+	enc := json.NewEncoder(res)
+
+	written := 0
+	start := func() {
+		if ndjson {
+			res.Header().Set("Content-Type", ndjsonContentType)
+		} else {
+			res.Header().Set("Content-Type", "application/json")
+		}
+		res.WriteHeader(http.StatusOK)
+
+		if !ndjson {
+			io.WriteString(res, "[")
+		}
+	}
+
+	err := ah.storage.Export(req.Context(), func(m models.Metrics) error {
+		if written == 0 {
+			start()
+		} else if !ndjson {
+			io.WriteString(res, ",")
+		}
+		written++
+
+		return enc.Encode(m)
+	})
+
+	if err != nil {
+		if written == 0 {
+			apierror.Write(res, http.StatusServiceUnavailable, apierror.CodeUnavailable, err.Error(), nil)
+		}
+		return
+	}
+
+	if written == 0 {
+		start()
+	}
+	if !ndjson {
+		io.WriteString(res, "]\n")
+	}
+}
+
+// Import loads a dump made by Export, sent as application/x-ndjson or a
+// JSON array. With ?mode=merge, the default, the dumped series overwrite the
+// stored ones and the others are kept. With ?mode=replace the storage holds
+// exactly the dump afterwards. Nothing is imported if any metric is invalid.
+func (ah *AdminHandler) Import(res http.ResponseWriter, req *http.Request) {
+	mode := req.URL.Query().Get("mode")
+	if mode == "" {
+		mode = importMerge
+	}
+
+	if mode != importMerge && mode != importReplace {
+		apierror.Write(res, http.StatusBadRequest, apierror.CodeImportModeInvalid, "Import mode is not supported!", map[string]interface{}{"mode": mode, "supported": []string{importMerge, importReplace}})
+		return
+	}
+
+	req.Body = http.MaxBytesReader(res, req.Body, maxImportBytes)
+
+	metrics, err := decodeDump(req)
+
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		apierror.Write(res, http.StatusRequestEntityTooLarge, apierror.CodeBodyTooLarge, "Dump is too large!", map[string]interface{}{"max_bytes": tooLarge.Limit})
+		return
+	}
+
+	if err != nil {
+		apierror.Write(res, http.StatusBadRequest, apierror.CodeInvalidBody, err.Error(), nil)
+		return
+	}
+
+	maxLabels := ah.reloader.Current().MaxLabels
+	for i, m := range metrics {
+		if v := validateDumped(m, maxLabels); v != nil {
+			if v.Details == nil {
+				v.Details = map[string]interface{}{}
+			}
+			v.Details["index"] = i
+			writeViolation(res, v)
+			return
+		}
+	}
+
+	if err := ah.storage.Import(req.Context(), metrics, mode == importReplace); err != nil {
+		apierror.Write(res, http.StatusServiceUnavailable, apierror.CodeUnavailable, err.Error(), nil)
+		return
+	}
+
+	resp, err := json.Marshal(map[string]interface{}{"mode": mode, "imported": len(metrics)})
+	if err != nil {
+		apierror.Write(res, http.StatusInternalServerError, apierror.CodeInternal, err.Error(), nil)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusOK)
+	res.Write(resp)
+}
+
+// decodeDump reads the metrics of an import request.
+func decodeDump(req *http.Request) ([]models.Metrics, error) {
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+
+	if mediaType != ndjsonContentType {
+		var metrics []models.Metrics
+		if err := json.NewDecoder(req.Body).Decode(&metrics); err != nil {
+			return nil, err
+		}
+		return metrics, nil
+	}
+
+	var metrics []models.Metrics
+
+	dec := json.NewDecoder(req.Body)
+	for {
+		var m models.Metrics
+		err := dec.Decode(&m)
+		if errors.Is(err, io.EOF) {
+			return metrics, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, m)
+	}
+}
+
+// validateDumped checks a metric of a dump. The name policies are not
+// applied, the metrics were accepted once already, but the name and host
+// have to fit in any storage, as validateMetric checks for the host.
+func validateDumped(m models.Metrics, maxLabels uint64) *validators.Violation {
+	if v := validators.ValidateNameLength(m.ID, config.MaxStoredNameLength); v != nil {
+		return v
+	}
+
+	return validateMetric(m, maxLabels)
+}
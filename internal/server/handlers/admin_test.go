@@ -0,0 +1,262 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lambawebdev/metrics/internal/models"
+	"github.com/lambawebdev/metrics/internal/server/config"
+	"github.com/lambawebdev/metrics/internal/server/middleware"
+	"github.com/lambawebdev/metrics/internal/server/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminReload(t *testing.T) {
+	tests := []struct {
+		name          string
+		token         string
+		authorization string
+		loadErr       error
+		wantStatus    int
+		wantReloaded  bool
+	}{
+		{
+			name:          "Test reload",
+			token:         "secret",
+			authorization: "Bearer secret",
+			wantStatus:    http.StatusOK,
+			wantReloaded:  true,
+		},
+		{
+			name:          "Test invalid config",
+			token:         "secret",
+			authorization: "Bearer secret",
+			loadErr:       errors.New("log_level: unrecognized level"),
+			wantStatus:    http.StatusUnprocessableEntity,
+		},
+		{
+			name:          "Test wrong token",
+			token:         "secret",
+			authorization: "Bearer guess",
+			wantStatus:    http.StatusUnauthorized,
+		},
+		{
+			name:       "Test no token",
+			token:      "secret",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:          "Test disabled",
+			authorization: "Bearer ",
+			wantStatus:    http.StatusNotFound,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.AdminToken = test.token
+
+			reloaded := config.Default()
+			reloader := config.NewReloader(cfg, func() (*config.Config, error) {
+				if test.loadErr != nil {
+					return nil, test.loadErr
+				}
+				return reloaded, nil
+			})
+
+			ah := NewAdminHandler(reloader, new(storage.MemStorage))
+			h := middleware.AdminAuthMiddleware(func() string { return reloader.Current().AdminToken }, ah.Reload)
+
+			req := httptest.NewRequest(http.MethodPost, "/admin/reload", nil)
+			if test.authorization != "" {
+				req.Header.Set("Authorization", test.authorization)
+			}
+			res := httptest.NewRecorder()
+
+			h(res, req)
+
+			assert.Equal(t, test.wantStatus, res.Code)
+			assert.Equal(t, test.wantReloaded, reloader.Current() == reloaded)
+		})
+	}
+}
+
+func newDumpStorage() *storage.MemStorage {
+	alloc := float64(1.5)
+	polls := int64(3)
+
+	h := models.NewHistogram([]float64{0.1, 1})
+	h.Observe(0.5)
+
+	s := new(storage.MemStorage)
+	s.Metrics = []models.Metrics{
+		{ID: "Alloc", MType: "gauge", Host: "web-1", Value: &alloc},
+		{ID: "PollCount", MType: "counter", Labels: models.Labels{"env": "prod"}, Delta: &polls},
+		{ID: "SendLatency", MType: "histogram", Host: "web-1", Histogram: h},
+	}
+
+	return s
+}
+
+func TestAdminExportImport(t *testing.T) {
+	tests := []struct {
+		name        string
+		query       string
+		accept      string
+		contentType string
+	}{
+		{name: "Test json", contentType: "application/json"},
+		{name: "Test ndjson query", query: "?format=ndjson", contentType: ndjsonContentType},
+		{name: "Test ndjson accept", accept: ndjsonContentType, contentType: ndjsonContentType},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source := newDumpStorage()
+
+			req := httptest.NewRequest(http.MethodGet, "/admin/export"+test.query, nil)
+			req.Header.Set("Accept", test.accept)
+			res := httptest.NewRecorder()
+			NewAdminHandler(config.NewReloader(config.Default(), nil), source).Export(res, req)
+
+			require.Equal(t, http.StatusOK, res.Code)
+			assert.Equal(t, test.contentType, res.Header().Get("Content-Type"))
+
+			target := new(storage.MemStorage)
+			req = httptest.NewRequest(http.MethodPost, "/admin/import?mode=replace", strings.NewReader(res.Body.String()))
+			req.Header.Set("Content-Type", test.contentType)
+			res = httptest.NewRecorder()
+			NewAdminHandler(config.NewReloader(config.Default(), nil), target).Import(res, req)
+
+			require.Equal(t, http.StatusOK, res.Code)
+			assert.JSONEq(t, `{"mode": "replace", "imported": 3}`, res.Body.String())
+			assert.Equal(t, source.GetAll(context.Background()), target.GetAll(context.Background()))
+		})
+	}
+}
+
+// brokenExport fails to export after the first metrics it reads.
+type brokenExport struct {
+	*storage.MemStorage
+	after int
+}
+
+func (b brokenExport) Export(ctx context.Context, fn func(m models.Metrics) error) error {
+	for _, m := range b.GetAll(ctx)[:b.after] {
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+
+	return errors.New("connection reset")
+}
+
+func TestAdminExportStreamed(t *testing.T) {
+	tests := []struct {
+		name     string
+		storage  storage.MetricStorage
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Test nothing stored",
+			storage:  new(storage.MemStorage),
+			wantCode: http.StatusOK,
+			wantBody: "[]\n",
+		},
+		{
+			name:     "Test failed before the first metric",
+			storage:  brokenExport{MemStorage: newDumpStorage()},
+			wantCode: http.StatusServiceUnavailable,
+			wantBody: `{"error":{"code":"unavailable","message":"connection reset"}}` + "\n",
+		},
+		{
+			name:     "Test failed after the first metric",
+			storage:  brokenExport{MemStorage: newDumpStorage(), after: 1},
+			wantCode: http.StatusOK,
+			wantBody: `[{"id":"Alloc","type":"gauge","value":1.5,"host":"web-1"}` + "\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res := httptest.NewRecorder()
+			NewAdminHandler(config.NewReloader(config.Default(), nil), test.storage).Export(res, httptest.NewRequest(http.MethodGet, "/admin/export", nil))
+
+			assert.Equal(t, test.wantCode, res.Code)
+			assert.Equal(t, test.wantBody, res.Body.String())
+		})
+	}
+}
+
+func TestAdminImportModes(t *testing.T) {
+	dump := `[{"id": "Alloc", "type": "gauge", "host": "web-1", "value": 7}, {"id": "Sys", "type": "gauge", "value": 2}]`
+
+	tests := []struct {
+		name     string
+		mode     string
+		body     string
+		code     int
+		wantCode string
+		want     []string
+	}{
+		{name: "Test merge by default", body: dump, code: 200, want: []string{"Alloc", "PollCount", "SendLatency", "Sys"}},
+		{name: "Test replace", mode: "replace", body: dump, code: 200, want: []string{"Alloc", "Sys"}},
+		{name: "Test unknown mode", mode: "append", body: dump, code: 400, wantCode: "import_mode_invalid", want: []string{"Alloc", "PollCount", "SendLatency"}},
+		{name: "Test invalid body", body: `{"id": "Alloc"`, code: 400, wantCode: "invalid_body", want: []string{"Alloc", "PollCount", "SendLatency"}},
+		{name: "Test missing value", mode: "replace", body: `[{"id": "Sys", "type": "gauge", "value": 2}, {"id": "Frees", "type": "counter"}]`, code: 400, wantCode: "metric_value_missing", want: []string{"Alloc", "PollCount", "SendLatency"}},
+		{name: "Test unknown type", body: `[{"id": "Sys", "type": "meter", "value": 2}]`, code: 400, wantCode: "metric_type_unsupported", want: []string{"Alloc", "PollCount", "SendLatency"}},
+		{name: "Test empty name", body: `[{"id": "", "type": "gauge", "value": 2}]`, code: 400, wantCode: "metric_name_empty", want: []string{"Alloc", "PollCount", "SendLatency"}},
+		{name: "Test host too long to store", body: `[{"id": "Sys", "type": "gauge", "host": "` + strings.Repeat("h", config.MaxStoredHostLength+1) + `", "value": 2}]`, code: 400, wantCode: "host_too_long", want: []string{"Alloc", "PollCount", "SendLatency"}},
+		{name: "Test dump too large", body: `[{"id": "Sys", "type": "gauge", "value": 2}` + strings.Repeat(` `, 1024) + `]`, code: 413, wantCode: "body_too_large", want: []string{"Alloc", "PollCount", "SendLatency"}},
+		{name: "Test name too long to store", body: `[{"id": "` + strings.Repeat("a", config.MaxStoredNameLength+1) + `", "type": "gauge", "value": 2}]`, code: 400, wantCode: "metric_name_too_long", want: []string{"Alloc", "PollCount", "SendLatency"}},
+	}
+	previous := maxImportBytes
+	t.Cleanup(func() { maxImportBytes = previous })
+	maxImportBytes = 1024
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newDumpStorage()
+			ah := NewAdminHandler(config.NewReloader(config.Default(), nil), s)
+
+			url := "/admin/import"
+			if test.mode != "" {
+				url += "?mode=" + test.mode
+			}
+			res := httptest.NewRecorder()
+			ah.Import(res, httptest.NewRequest(http.MethodPost, url, strings.NewReader(test.body)))
+
+			require.Equal(t, test.code, res.Code)
+			if test.wantCode != "" {
+				var body struct {
+					Error struct {
+						Code string `json:"code"`
+					} `json:"error"`
+				}
+				require.NoError(t, json.Unmarshal(res.Body.Bytes(), &body))
+				assert.Equal(t, test.wantCode, body.Error.Code)
+			}
+
+			var names []string
+			for _, m := range s.GetAll(context.Background()) {
+				names = append(names, m.ID)
+			}
+			assert.ElementsMatch(t, test.want, names)
+		})
+	}
+
+	// A merged series takes the dumped value rather than adding to it.
+	s := newDumpStorage()
+	res := httptest.NewRecorder()
+	NewAdminHandler(config.NewReloader(config.Default(), nil), s).Import(res, httptest.NewRequest(http.MethodPost, "/admin/import", strings.NewReader(dump)))
+	require.Equal(t, http.StatusOK, res.Code)
+
+	m, ok := s.GetMetric(context.Background(), "web-1", "Alloc", "gauge", nil)
+	require.True(t, ok)
+	assert.Equal(t, float64(7), *m.Value)
+}
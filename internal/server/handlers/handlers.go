@@ -2,53 +2,96 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
-	"database/sql"
 	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"strconv"
+	"sync/atomic"
 
 	"github.com/lambawebdev/metrics/internal/models"
+	"github.com/lambawebdev/metrics/internal/server/apierror"
 	"github.com/lambawebdev/metrics/internal/server/config"
+	"github.com/lambawebdev/metrics/internal/server/prometheus"
+	"github.com/lambawebdev/metrics/internal/server/selfmetrics"
 	"github.com/lambawebdev/metrics/internal/server/storage"
 	"github.com/lambawebdev/metrics/internal/validators"
 )
 
 const (
-	counter string = "counter"
-	gauge   string = "gauge"
+	counter   string = "counter"
+	gauge     string = "gauge"
+	histogram string = "histogram"
+	summary   string = "summary"
+)
+
+const (
+	idempotencyKeyHeader     = "Idempotency-Key"
+	idempotentReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength  = 128
 )
 
 type MetricHandler struct {
 	storage storage.MetricStorage
+	current atomic.Pointer[settings]
+}
+
+// settings are the config the handler runs with and what it derives from it.
+type settings struct {
+	*config.Config
+	namePolicy *validators.NamePolicy
+}
+
+// newSettings prepares cfg for the handler. cfg must have passed validation.
+func newSettings(cfg *config.Config) *settings {
+	namePolicy, err := cfg.NamePolicy()
+	if err != nil {
+		panic(fmt.Sprintf("invalid metric name policy: %v", err))
+	}
+
+	return &settings{Config: cfg, namePolicy: namePolicy}
 }
 
 type MetricHandlerInterface interface {
 	GetMetric(res http.ResponseWriter, req *http.Request)
 	GetMetricV2(res http.ResponseWriter, req *http.Request)
-	GetMetrics(res http.ResponseWriter)
+	GetMetrics(res http.ResponseWriter, req *http.Request)
+	GetMetricsPrometheus(res http.ResponseWriter, req *http.Request)
 	UpdateMetric(res http.ResponseWriter, req *http.Request)
 	UpdateMetricV2(res http.ResponseWriter, req *http.Request)
-	Ping(res http.ResponseWriter, db *sql.DB)
+	Ping(res http.ResponseWriter, req *http.Request)
 }
 
-func NewMetricHandler(storage storage.MetricStorage) *MetricHandler {
-	return &MetricHandler{
+func NewMetricHandler(storage storage.MetricStorage, cfg *config.Config) *MetricHandler {
+	mh := &MetricHandler{
 		storage: storage,
 	}
+	mh.current.Store(newSettings(cfg))
+
+	return mh
+}
+
+// SetConfig makes the handler use cfg for the requests that follow.
+func (mh *MetricHandler) SetConfig(cfg *config.Config) {
+	mh.current.Store(newSettings(cfg))
 }
 
 func (mh *MetricHandler) GetMetric(res http.ResponseWriter, req *http.Request) {
 	metricType := req.PathValue("type")
 	metricName := req.PathValue("name")
+	host := req.URL.Query().Get("host")
+
+	if !writeViolation(res, validators.ValidateMetricType(metricType)) {
+		return
+	}
 
-	validators.ValidateMetricType(metricType, res)
-	metric, found := mh.storage.GetMetric(metricName, metricType)
+	metric, found := mh.storage.GetMetric(req.Context(), host, metricName, metricType, nil)
 
 	if !found {
-		http.Error(res, "Metric not exists!", http.StatusNotFound)
+		writeNotFound(res, host, metricName, metricType)
 		return
 	}
 
@@ -62,21 +105,63 @@ func (mh *MetricHandler) GetMetric(res http.ResponseWriter, req *http.Request) {
 		value = metric.Delta
 	}
 
+	if metricType == histogram {
+		value = metric.Histogram
+	}
+
+	if metricType == summary {
+		value = metric.Summary
+	}
+
 	res.Header().Set("content-Type", "application/json")
 	res.WriteHeader(http.StatusOK)
 	json.NewEncoder(res).Encode(value)
 }
 
-func (mh *MetricHandler) GetMetrics(res http.ResponseWriter) {
-	metricsValues := mh.storage.GetAll()
+// GetMetrics lists stored metrics. The optional "host" query parameter keeps
+// only the metrics of one agent, and "group=host" returns them as an object
+// keyed by host instead of a flat list. Metrics reported without an agent
+// identity are listed under the empty host. Every "match" parameter is a
+// label matcher such as region="eu", disk!="sda" or service=~"api.*".
+func (mh *MetricHandler) GetMetrics(res http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+
+	var matchers []models.Matcher
+	for _, expr := range query["match"] {
+		matcher, err := models.ParseMatcher(expr)
+		if err != nil {
+			apierror.Write(res, http.StatusBadRequest, apierror.CodeMatcherInvalid, err.Error(), map[string]interface{}{"match": expr})
+			return
+		}
+		matchers = append(matchers, matcher)
+	}
+
+	metricsValues := mh.storage.GetAll(req.Context())
+
+	if query.Has("host") {
+		metricsValues = filterByHost(metricsValues, query.Get("host"))
+	}
+
+	if len(matchers) > 0 {
+		metricsValues = filterByLabels(metricsValues, matchers)
+	}
+
+	var body interface{} = metricsValues
+	if query.Get("group") == "host" {
+		body = groupByHost(metricsValues)
+	}
 
 	res.Header().Set("Content-Type", "text/html")
 	res.WriteHeader(http.StatusOK)
 
-	if err := json.NewEncoder(res).Encode(metricsValues); err != nil {
-		http.Error(res, err.Error(), http.StatusBadRequest)
-		return
-	}
+	json.NewEncoder(res).Encode(body)
+}
+
+func (mh *MetricHandler) GetMetricsPrometheus(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", prometheus.ContentType)
+	res.WriteHeader(http.StatusOK)
+
+	prometheus.Write(res, mh.storage.GetAll(req.Context()))
 }
 
 func (mh *MetricHandler) GetMetricV2(res http.ResponseWriter, req *http.Request) {
@@ -85,21 +170,30 @@ func (mh *MetricHandler) GetMetricV2(res http.ResponseWriter, req *http.Request)
 
 	_, err := buf.ReadFrom(req.Body)
 	if err != nil {
-		http.Error(res, err.Error(), http.StatusBadRequest)
+		apierror.Write(res, http.StatusBadRequest, apierror.CodeInvalidBody, err.Error(), nil)
 		return
 	}
 
 	if err = json.Unmarshal(buf.Bytes(), &m); err != nil {
-		http.Error(res, err.Error(), http.StatusBadRequest)
+		apierror.Write(res, http.StatusBadRequest, apierror.CodeInvalidBody, err.Error(), nil)
+		return
+	}
+
+	if !writeViolation(res, validators.ValidateMetricType(m.MType)) {
 		return
 	}
 
-	m, _ = mh.storage.GetMetric(m.ID, m.MType)
+	metric, found := mh.storage.GetMetric(req.Context(), m.Host, m.ID, m.MType, m.Labels)
 
-	resp, err := json.Marshal(m)
+	if !found {
+		writeNotFound(res, m.Host, m.ID, m.MType)
+		return
+	}
+
+	resp, err := json.Marshal(metric)
 
 	if err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+		apierror.Write(res, http.StatusInternalServerError, apierror.CodeInternal, err.Error(), nil)
 		return
 	}
 
@@ -112,18 +206,31 @@ func (mh *MetricHandler) UpdateMetric(res http.ResponseWriter, req *http.Request
 	metricType := req.PathValue("type")
 	metricName := req.PathValue("name")
 	metricValue := req.PathValue("value")
+	host := req.URL.Query().Get("host")
 
-	validators.ValidateMetricType(metricType, res)
-	validators.ValidateMetricValue(metricType, metricValue, res)
+	if !writeViolation(res, validators.ValidateMetricType(metricType)) ||
+		!writeViolation(res, validators.ValidateHost(host, config.MaxStoredHostLength)) ||
+		!writeViolation(res, validators.ValidateMetricValue(metricType, metricValue)) {
+		return
+	}
 
+	m := models.Metrics{ID: metricName, MType: metricType, Host: host}
 	if metricType == gauge {
 		value, _ := strconv.ParseFloat(metricValue, 64)
-		mh.storage.AddGauge(metricName, value)
+		m.Value = &value
+	}
+
+	if !mh.validatePolicy(req.Context(), m, nil, res) {
+		return
+	}
+
+	if metricType == gauge {
+		mh.storage.AddGauge(req.Context(), host, metricName, nil, *m.Value)
 	}
 
 	if metricType == counter {
 		value, _ := strconv.ParseInt(metricValue, 10, 64)
-		mh.storage.AddCounter(metricName, value)
+		mh.storage.AddCounter(req.Context(), host, metricName, nil, value)
 	}
 
 	res.Header().Set("content-Type", "text/plain; charset=utf-8")
@@ -136,55 +243,49 @@ func (mh *MetricHandler) UpdateMetricV2(res http.ResponseWriter, req *http.Reque
 
 	_, err := buf.ReadFrom(req.Body)
 	if err != nil {
-		http.Error(res, err.Error(), http.StatusBadRequest)
+		apierror.Write(res, http.StatusBadRequest, apierror.CodeInvalidBody, err.Error(), nil)
 		return
 	}
 
-	if config.GetSecretKey() != "" && req.Header.Get("HashSHA256") != "" {
-		secretKey := []byte(config.GetSecretKey())
-
-		equal, err := verifyHmac(buf.Bytes(), secretKey, req.Header.Get("HashSHA256"))
-		if err != nil {
-			http.Error(res, err.Error(), http.StatusInternalServerError)
-			return
-		}
-
-		if !equal {
-			http.Error(res, "hash not equals", http.StatusBadRequest)
-			return
-		}
+	if !mh.verifyRequestHash(buf.Bytes(), req, res) {
+		return
 	}
 
 	if err = json.Unmarshal(buf.Bytes(), &m); err != nil {
-		http.Error(res, err.Error(), http.StatusBadRequest)
+		apierror.Write(res, http.StatusBadRequest, apierror.CodeInvalidBody, err.Error(), nil)
 		return
 	}
 
-	if !validators.ValidateMetricType(m.MType, res) {
+	if !writeViolation(res, validateMetric(m, mh.current.Load().MaxLabels)) ||
+		!mh.validatePolicy(req.Context(), m, nil, res) {
 		return
 	}
 
 	if m.MType == gauge {
-		if m.Value == nil {
-			http.Error(res, "value have to be present", http.StatusBadRequest)
-			return
-		}
-
-		mh.storage.AddGauge(m.ID, *m.Value)
+		mh.storage.AddGauge(req.Context(), m.Host, m.ID, m.Labels, *m.Value)
 	}
 
 	if m.MType == counter {
-		if m.Delta == nil {
-			http.Error(res, "delta have to be present", http.StatusBadRequest)
+		mh.storage.AddCounter(req.Context(), m.Host, m.ID, m.Labels, *m.Delta)
+	}
+
+	if m.MType == histogram || m.MType == summary {
+		if m.MType == histogram {
+			err = mh.storage.AddHistogram(req.Context(), m.Host, m.ID, m.Labels, *m.Histogram)
+		} else {
+			err = mh.storage.AddSummary(req.Context(), m.Host, m.ID, m.Labels, *m.Summary)
+		}
+
+		if err != nil {
+			apierror.Write(res, http.StatusBadRequest, apierror.CodeDistributionInvalid, err.Error(), map[string]interface{}{"name": m.ID})
 			return
 		}
-		mh.storage.AddCounter(m.ID, *m.Delta)
 	}
 
 	resp, err := json.Marshal(m)
 
 	if err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+		apierror.Write(res, http.StatusInternalServerError, apierror.CodeInternal, err.Error(), nil)
 		return
 	}
 
@@ -193,9 +294,12 @@ func (mh *MetricHandler) UpdateMetricV2(res http.ResponseWriter, req *http.Reque
 	res.Write(resp)
 }
 
-func (mh *MetricHandler) Ping(res http.ResponseWriter, db *sql.DB) {
-	if err := db.Ping(); err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+// Ping reports whether the storage can be reached. Without a database the
+// metrics are in memory and it always can.
+func (mh *MetricHandler) Ping(res http.ResponseWriter, req *http.Request) {
+	if err := mh.storage.Ping(req.Context()); err != nil {
+		apierror.Write(res, http.StatusInternalServerError, apierror.CodeUnavailable, err.Error(), nil)
+		return
 	}
 
 	res.WriteHeader(http.StatusOK)
@@ -208,19 +312,302 @@ func (mh *MetricHandler) UpdateMetricBatch(res http.ResponseWriter, req *http.Re
 
 	_, err := buf.ReadFrom(req.Body)
 	if err != nil {
-		http.Error(res, err.Error(), http.StatusBadRequest)
+		apierror.Write(res, http.StatusBadRequest, apierror.CodeInvalidBody, err.Error(), nil)
+		return
+	}
+
+	if !mh.verifyRequestHash(buf.Bytes(), req, res) {
 		return
 	}
 
 	if err = json.Unmarshal(buf.Bytes(), &metrics); err != nil {
-		http.Error(res, err.Error(), http.StatusBadRequest)
+		apierror.Write(res, http.StatusBadRequest, apierror.CodeInvalidBody, err.Error(), nil)
+		return
+	}
+
+	maxLabels := mh.current.Load().MaxLabels
+	for i, m := range metrics {
+		if v := validateMetric(m, maxLabels); v != nil {
+			if v.Details == nil {
+				v.Details = map[string]interface{}{}
+			}
+			v.Details["index"] = i
+			writeViolation(res, v)
+			return
+		}
+	}
+
+	// Metrics breaking a policy are left out and listed in the response, so
+	// that they do not cost the others in the batch.
+	result := batchResult{}
+	accepted := make([]models.Metrics, 0, len(metrics))
+
+	added := newBatchSeries()
+	for i, m := range metrics {
+		if v := mh.rejectByPolicy(req.Context(), m, added); v != nil {
+			result.Rejected = append(result.Rejected, rejectedMetric{Index: i, Error: apierror.Error{Code: v.Code, Message: v.Message, Details: v.Details}})
+			continue
+		}
+
+		accepted = append(accepted, m)
+	}
+	result.Accepted = len(accepted)
+	metrics = accepted
+
+	key := req.Header.Get(idempotencyKeyHeader)
+	if len(key) > maxIdempotencyKeyLength {
+		apierror.Write(res, http.StatusBadRequest, apierror.CodeIdempotencyKeyInvalid, "Idempotency key is too long!", map[string]interface{}{"max_length": maxIdempotencyKeyLength})
 		return
 	}
 
-	mh.storage.AddBatch(metrics)
+	if key == "" {
+		mh.storage.AddBatch(req.Context(), metrics)
+	} else {
+		applied, err := mh.storage.AddBatchOnce(req.Context(), key, metrics)
+		if err != nil {
+			// Nothing was stored, the agent has to send the batch again.
+			apierror.Write(res, http.StatusServiceUnavailable, apierror.CodeUnavailable, err.Error(), nil)
+			return
+		}
+
+		if !applied {
+			// The batch was already applied, most likely by a request the
+			// agent gave up on. Acknowledge it so the agent stops retrying.
+			res.Header().Set(idempotentReplayedHeader, "true")
+		}
+	}
+
+	resp, err := json.Marshal(result)
+	if err != nil {
+		apierror.Write(res, http.StatusInternalServerError, apierror.CodeInternal, err.Error(), nil)
+		return
+	}
 
 	res.Header().Set("Content-Type", "application/json")
 	res.WriteHeader(http.StatusOK)
+	res.Write(resp)
+}
+
+// batchResult is the response to a batch: how many metrics were stored and
+// why the others were not.
+type batchResult struct {
+	Accepted int              `json:"accepted"`
+	Rejected []rejectedMetric `json:"rejected,omitempty"`
+}
+
+// rejectedMetric is a metric of a batch left out, by its index in the batch.
+type rejectedMetric struct {
+	Index int `json:"index"`
+	apierror.Error
+}
+
+// batchSeries are the new series added by the metrics of a batch checked so
+// far, so that the series limits count them before they are stored.
+type batchSeries struct {
+	keys   map[string]bool
+	byName map[string]int
+}
+
+func newBatchSeries() *batchSeries {
+	return &batchSeries{keys: make(map[string]bool), byName: make(map[string]int)}
+}
+
+func (b *batchSeries) add(m models.Metrics) {
+	b.keys[m.Key()] = true
+	b.byName[m.ID]++
+}
+
+// validateMetric checks that a metric can be stored: its type is known, its
+// labels are within limits and it carries the value of its type.
+func validateMetric(m models.Metrics, maxLabels uint64) *validators.Violation {
+	if v := validators.ValidateMetricType(m.MType); v != nil {
+		return v
+	}
+
+	if v := validators.ValidateHost(m.Host, config.MaxStoredHostLength); v != nil {
+		return v
+	}
+
+	if v := validators.ValidateLabels(m.Labels, maxLabels); v != nil {
+		return v
+	}
+
+	switch m.MType {
+	case gauge:
+		if m.Value == nil {
+			return &validators.Violation{Code: apierror.CodeMetricValueMissing, Message: "value have to be present", Details: map[string]interface{}{"name": m.ID}}
+		}
+	case counter:
+		if m.Delta == nil {
+			return &validators.Violation{Code: apierror.CodeMetricValueMissing, Message: "delta have to be present", Details: map[string]interface{}{"name": m.ID}}
+		}
+	default:
+		return validators.ValidateDistribution(m)
+	}
+
+	return nil
+}
+
+// validatePolicy rejects a metric that breaks the policies of the server,
+// counting the rejection by reason. added holds the new series of the batch
+// m is in, nil for a single metric.
+func (mh *MetricHandler) validatePolicy(ctx context.Context, m models.Metrics, added *batchSeries, res http.ResponseWriter) bool {
+	return writeViolation(res, mh.rejectByPolicy(ctx, m, added))
+}
+
+// rejectByPolicy returns why m breaks the policies of the server, if it
+// does, counting the rejection by reason.
+func (mh *MetricHandler) rejectByPolicy(ctx context.Context, m models.Metrics, added *batchSeries) *validators.Violation {
+	v := mh.checkPolicy(ctx, m, added)
+	if v != nil {
+		selfmetrics.Default.Add("MetricsRejected", models.Labels{"reason": v.Code}, 1)
+	}
+
+	return v
+}
+
+// checkPolicy checks the name and value of a metric and, if it is a new
+// series, that there is room for it next to the stored series and those
+// added before it. A new series that fits is recorded in added.
+func (mh *MetricHandler) checkPolicy(ctx context.Context, m models.Metrics, added *batchSeries) *validators.Violation {
+	current := mh.current.Load()
+
+	if v := current.namePolicy.Check(m.ID); v != nil {
+		return v
+	}
+
+	if m.MType == gauge && m.Value != nil {
+		if v := validators.CheckGaugeValue(m.ID, *m.Value); v != nil {
+			return v
+		}
+	}
+
+	if current.MaxSeriesPerMetric == 0 && current.MaxSeries == 0 {
+		return nil
+	}
+
+	if added == nil {
+		added = newBatchSeries()
+	}
+
+	if added.keys[m.Key()] {
+		return nil
+	}
+
+	// Without a host, the storage may find the series of another host.
+	if stored, found := mh.storage.GetMetric(ctx, m.Host, m.ID, m.MType, m.Labels); found && stored.Host == m.Host {
+		return nil
+	}
+
+	if limit := current.MaxSeriesPerMetric; limit != 0 && uint64(mh.storage.CountSeries(ctx, m.ID)+added.byName[m.ID]) >= limit {
+		return &validators.Violation{
+			Code:    validators.CodeTooMany,
+			Message: "Too many series for metric!",
+			Details: map[string]interface{}{"name": m.ID, "limit": limit},
+		}
+	}
+
+	if limit := current.MaxSeries; limit != 0 && uint64(mh.storage.CountAllSeries(ctx)+len(added.keys)) >= limit {
+		return &validators.Violation{
+			Code:    validators.CodeTooMany,
+			Message: "Too many series!",
+			Details: map[string]interface{}{"limit": limit},
+		}
+	}
+
+	added.add(m)
+	return nil
+}
+
+// writeViolation answers with v, if there is one, and reports whether the
+// request can go on.
+func writeViolation(res http.ResponseWriter, v *validators.Violation) bool {
+	if v == nil {
+		return true
+	}
+
+	status := http.StatusBadRequest
+	if v.Code == apierror.CodeMetricNotFound {
+		status = http.StatusNotFound
+	}
+
+	apierror.Write(res, status, v.Code, v.Message, v.Details)
+	return false
+}
+
+func writeNotFound(res http.ResponseWriter, host string, metricName string, metricType string) {
+	details := map[string]interface{}{"type": metricType, "name": metricName}
+	if host != "" {
+		details["host"] = host
+	}
+
+	apierror.Write(res, http.StatusNotFound, apierror.CodeMetricNotFound, "Metric not exists!", details)
+}
+
+func filterByHost(metrics []models.Metrics, host string) []models.Metrics {
+	filtered := []models.Metrics{}
+
+	for _, m := range metrics {
+		if m.Host == host {
+			filtered = append(filtered, m)
+		}
+	}
+
+	return filtered
+}
+
+func filterByLabels(metrics []models.Metrics, matchers []models.Matcher) []models.Metrics {
+	filtered := []models.Metrics{}
+
+	for _, m := range metrics {
+		if matchesAll(m.Labels, matchers) {
+			filtered = append(filtered, m)
+		}
+	}
+
+	return filtered
+}
+
+func matchesAll(labels models.Labels, matchers []models.Matcher) bool {
+	for _, matcher := range matchers {
+		if !matcher.Matches(labels) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func groupByHost(metrics []models.Metrics) map[string][]models.Metrics {
+	grouped := make(map[string][]models.Metrics)
+
+	for _, m := range metrics {
+		grouped[m.Host] = append(grouped[m.Host], m)
+	}
+
+	return grouped
+}
+
+// verifyRequestHash checks the HashSHA256 header, if both it and the secret
+// key are set, against the uncompressed request body.
+func (mh *MetricHandler) verifyRequestHash(body []byte, req *http.Request, res http.ResponseWriter) bool {
+	key := mh.current.Load().Key
+	if key == "" || req.Header.Get("HashSHA256") == "" {
+		return true
+	}
+
+	equal, err := verifyHmac(body, []byte(key), req.Header.Get("HashSHA256"))
+	if err != nil {
+		apierror.Write(res, http.StatusInternalServerError, apierror.CodeInternal, err.Error(), nil)
+		return false
+	}
+
+	if !equal {
+		apierror.Write(res, http.StatusBadRequest, apierror.CodeHashMismatch, "hash not equals", nil)
+		return false
+	}
+
+	return true
 }
 
 func verifyHmac(msg, key []byte, hash string) (bool, error) {
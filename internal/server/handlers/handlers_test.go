@@ -3,12 +3,16 @@ package handlers
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/lambawebdev/metrics/internal/models"
 	"github.com/lambawebdev/metrics/internal/server/config"
@@ -18,6 +22,28 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
+// statusRecorder counts the statuses a handler writes, which must be one.
+type statusRecorder struct {
+	*httptest.ResponseRecorder
+	statuses int
+}
+
+func newStatusRecorder() *statusRecorder {
+	return &statusRecorder{ResponseRecorder: httptest.NewRecorder()}
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.statuses++
+	r.ResponseRecorder.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.statuses == 0 {
+		r.statuses++
+	}
+	return r.ResponseRecorder.Write(b)
+}
+
 func TestGetMetrics(t *testing.T) {
 	type want struct {
 		code         int
@@ -29,6 +55,7 @@ func TestGetMetrics(t *testing.T) {
 	tests := []struct {
 		name         string
 		readFromFile bool
+		query        string
 		want         want
 	}{
 		{
@@ -37,10 +64,37 @@ func TestGetMetrics(t *testing.T) {
 			want: want{
 				code:         200,
 				metricValue:  125,
+				responseText: `[{"value":125, "id":"Alloc", "type":"gauge"}, {"value":7, "id":"Alloc", "type":"gauge", "host":"web-1"}]`,
+				contentType:  "text/html",
+			},
+		},
+		{
+			name:  "Test filter by host",
+			query: "?host=web-1",
+			want: want{
+				code:         200,
+				responseText: `[{"value":7, "id":"Alloc", "type":"gauge", "host":"web-1"}]`,
+				contentType:  "text/html",
+			},
+		},
+		{
+			name:  "Test filter unlabeled",
+			query: "?host=",
+			want: want{
+				code:         200,
 				responseText: `[{"value":125, "id":"Alloc", "type":"gauge"}]`,
 				contentType:  "text/html",
 			},
 		},
+		{
+			name:  "Test group by host",
+			query: "?group=host",
+			want: want{
+				code:         200,
+				responseText: `{"": [{"value":125, "id":"Alloc", "type":"gauge"}], "web-1": [{"value":7, "id":"Alloc", "type":"gauge", "host":"web-1"}]}`,
+				contentType:  "text/html",
+			},
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
@@ -50,13 +104,17 @@ func TestGetMetrics(t *testing.T) {
 			metric.MType = "gauge"
 			metric.Value = &v
 
+			hostValue := float64(7)
+			hostMetric := models.Metrics{ID: "Alloc", MType: "gauge", Value: &hostValue, Host: "web-1"}
+
 			storage := new(storage.MemStorage)
-			storage.Metrics = []models.Metrics{metric}
+			storage.Metrics = []models.Metrics{metric, hostMetric}
 
-			mh := NewMetricHandler(storage)
+			mh := NewMetricHandler(storage, config.Default())
 
+			request := httptest.NewRequest(http.MethodGet, "/"+test.query, nil)
 			w := httptest.NewRecorder()
-			mh.GetMetrics(w)
+			mh.GetMetrics(w, request)
 
 			res := w.Result()
 			assert.Equal(t, test.want.code, res.StatusCode)
@@ -109,8 +167,20 @@ func TestGetMetric(t *testing.T) {
 			want: want{
 				code:         404,
 				metricValue:  125,
-				responseText: "Metric not exists!\n",
-				contentType:  "text/plain; charset=utf-8",
+				responseText: `{"error":{"code":"metric_not_found","message":"Metric not exists!","details":{"name":"BuckHashSys","type":"gauge"}}}` + "\n",
+				contentType:  "application/json",
+			},
+		},
+		{
+			name: "Test wrong metric type",
+			routeParams: routeParams{
+				metricType: "wrongType",
+				metricName: "Alloc",
+			},
+			want: want{
+				code:         400,
+				responseText: `{"error":{"code":"metric_type_unsupported","message":"Metric type is not supported!","details":{"supported":["gauge","counter","histogram","summary"],"type":"wrongType"}}}` + "\n",
+				contentType:  "application/json",
 			},
 		},
 	}
@@ -130,12 +200,13 @@ func TestGetMetric(t *testing.T) {
 
 			storage := new(storage.MemStorage)
 			storage.Metrics = []models.Metrics{metric}
-			h := NewMetricHandler(storage)
+			h := NewMetricHandler(storage, config.Default())
 
-			w := httptest.NewRecorder()
+			w := newStatusRecorder()
 			h.GetMetric(w, request)
 
 			res := w.Result()
+			assert.Equal(t, 1, w.statuses)
 			assert.Equal(t, test.want.code, res.StatusCode)
 			defer res.Body.Close()
 			resBody, err := io.ReadAll(res.Body)
@@ -174,15 +245,15 @@ func TestGetMetricV2(t *testing.T) {
 			},
 		},
 		{
-			name:         "Test ok",
+			name:         "Test unknown counter",
 			readFromFile: false,
 			body: &models.Metrics{
 				ID:    "PollCount",
 				MType: "counter",
 			},
 			want: want{
-				code:         200,
-				responseText: "{\"id\":\"PollCount\",\"type\":\"counter\",\"delta\":0}",
+				code:         404,
+				responseText: `{"error":{"code":"metric_not_found","message":"Metric not exists!","details":{"name":"PollCount","type":"counter"}}}` + "\n",
 				contentType:  "application/json",
 			},
 		},
@@ -194,8 +265,8 @@ func TestGetMetricV2(t *testing.T) {
 				MType: "gauge",
 			},
 			want: want{
-				code:         200,
-				responseText: "{\"id\":\"RandomValue\",\"type\":\"gauge\",\"value\":0}",
+				code:         404,
+				responseText: `{"error":{"code":"metric_not_found","message":"Metric not exists!","details":{"name":"RandomValue","type":"gauge"}}}` + "\n",
 				contentType:  "application/json",
 			},
 		},
@@ -207,8 +278,8 @@ func TestGetMetricV2(t *testing.T) {
 				MType: "gauge",
 			},
 			want: want{
-				code:         200,
-				responseText: "{\"id\":\"RandomValue\",\"type\":\"gauge\",\"value\":0}",
+				code:         404,
+				responseText: `{"error":{"code":"metric_not_found","message":"Metric not exists!","details":{"name":"RandomValue","type":"gauge"}}}` + "\n",
 				contentType:  "application/json",
 			},
 		},
@@ -220,8 +291,8 @@ func TestGetMetricV2(t *testing.T) {
 				MType: "counter",
 			},
 			want: want{
-				code:         200,
-				responseText: "{\"id\":\"RandomValue\",\"type\":\"counter\",\"delta\":0}",
+				code:         404,
+				responseText: `{"error":{"code":"metric_not_found","message":"Metric not exists!","details":{"name":"RandomValue","type":"counter"}}}` + "\n",
 				contentType:  "application/json",
 			},
 		},
@@ -246,14 +317,13 @@ func TestGetMetricV2(t *testing.T) {
 				MType: "counter",
 			},
 			want: want{
-				code:         200,
-				responseText: "{\"id\":\"GetSetZip\",\"type\":\"counter\",\"delta\":0}",
+				code:         404,
+				responseText: `{"error":{"code":"metric_not_found","message":"Metric not exists!","details":{"name":"GetSetZip","type":"counter"}}}` + "\n",
 				contentType:  "application/json",
 			},
 		},
 	}
 
-	config.ParseFlags()
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			body, _ := json.Marshal(test.body)
@@ -268,8 +338,9 @@ func TestGetMetricV2(t *testing.T) {
 
 			storage := new(storage.MemStorage)
 			storage.Metrics = []models.Metrics{metric}
-			mh := NewMetricHandler(storage)
-			config.SetRestoreMetrics(test.readFromFile)
+			cfg := config.Default()
+			cfg.Restore = test.readFromFile
+			mh := NewMetricHandler(storage, cfg)
 
 			w := httptest.NewRecorder()
 			mh.GetMetricV2(w, request)
@@ -337,8 +408,8 @@ func TestUpdateMetriccc(t *testing.T) {
 			},
 			want: want{
 				code:         400,
-				responseText: "Metric type is not supported!\n",
-				contentType:  "text/plain; charset=utf-8",
+				responseText: `{"error":{"code":"metric_type_unsupported","message":"Metric type is not supported!","details":{"supported":["gauge","counter","histogram","summary"],"type":"wrongType"}}}` + "\n",
+				contentType:  "application/json",
 			},
 		},
 		{
@@ -350,8 +421,8 @@ func TestUpdateMetriccc(t *testing.T) {
 			},
 			want: want{
 				code:         400,
-				responseText: "Metric value not supported!\n",
-				contentType:  "text/plain; charset=utf-8",
+				responseText: `{"error":{"code":"metric_value_invalid","message":"Metric value not supported!","details":{"type":"gauge","value":"string"}}}` + "\n",
+				contentType:  "application/json",
 			},
 		},
 	}
@@ -368,11 +439,12 @@ func TestUpdateMetriccc(t *testing.T) {
 			var Metrics []models.Metrics
 			storage.Metrics = Metrics
 
-			w := httptest.NewRecorder()
-			mh := NewMetricHandler(storage)
+			w := newStatusRecorder()
+			mh := NewMetricHandler(storage, config.Default())
 			mh.UpdateMetric(w, request)
 
 			res := w.Result()
+			assert.Equal(t, 1, w.statuses)
 			assert.Equal(t, test.want.code, res.StatusCode)
 			defer res.Body.Close()
 			resBody, err := io.ReadAll(res.Body)
@@ -436,8 +508,8 @@ func TestUpdateMetricV2(t *testing.T) {
 			},
 			want: want{
 				code:           400,
-				responseText:   "Metric type is not supported!\n",
-				contentType:    "text/plain; charset=utf-8",
+				responseText:   `{"error":{"code":"metric_type_unsupported","message":"Metric type is not supported!","details":{"supported":["gauge","counter","histogram","summary"],"type":"wrongType"}}}` + "\n",
+				contentType:    "application/json",
 				acceptEncoding: "gzip",
 			},
 		},
@@ -449,8 +521,8 @@ func TestUpdateMetricV2(t *testing.T) {
 			},
 			want: want{
 				code:           400,
-				responseText:   "delta have to be present\n",
-				contentType:    "text/plain; charset=utf-8",
+				responseText:   `{"error":{"code":"metric_value_missing","message":"delta have to be present","details":{"name":"PollCount"}}}` + "\n",
+				contentType:    "application/json",
 				acceptEncoding: "gzip",
 			},
 		},
@@ -459,7 +531,7 @@ func TestUpdateMetricV2(t *testing.T) {
 	storage := new(storage.MemStorage)
 	var Metrics []models.Metrics
 	storage.Metrics = Metrics
-	mh := NewMetricHandler(storage)
+	mh := NewMetricHandler(storage, config.Default())
 
 	handler := http.HandlerFunc(middleware.GzipMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		mh.UpdateMetricV2(w, r)
@@ -494,3 +566,466 @@ func TestUpdateMetricV2(t *testing.T) {
 		})
 	}
 }
+
+func TestUpdateMetricV2PerHost(t *testing.T) {
+	delta := int64(3)
+
+	storage := new(storage.MemStorage)
+	mh := NewMetricHandler(storage, config.Default())
+
+	for _, host := range []string{"web-1", "web-2", "web-1", ""} {
+		body, _ := json.Marshal(models.Metrics{ID: "PollCount", MType: "counter", Delta: &delta, Host: host})
+		request := httptest.NewRequest(http.MethodPost, "/update/", bytes.NewBuffer(body))
+
+		w := httptest.NewRecorder()
+		mh.UpdateMetricV2(w, request)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	tests := []struct {
+		host  string
+		delta int64
+	}{
+		{host: "web-1", delta: 6},
+		{host: "web-2", delta: 3},
+		{host: "", delta: 3},
+	}
+	for _, test := range tests {
+		m, found := storage.GetMetric(context.Background(), test.host, "PollCount", "counter", nil)
+		require.True(t, found)
+		assert.Equal(t, test.delta, *m.Delta)
+	}
+}
+
+func TestGetMetricsLabelMatchers(t *testing.T) {
+	v1, v2, v3 := float64(1), float64(2), float64(3)
+
+	storage := new(storage.MemStorage)
+	storage.Metrics = []models.Metrics{
+		{ID: "DiskUsed", MType: "gauge", Value: &v1, Labels: models.Labels{"disk": "sda", "region": "eu"}},
+		{ID: "DiskUsed", MType: "gauge", Value: &v2, Labels: models.Labels{"disk": "sdb", "region": "us"}},
+		{ID: "Alloc", MType: "gauge", Value: &v3},
+	}
+	mh := NewMetricHandler(storage, config.Default())
+
+	tests := []struct {
+		name  string
+		query string
+		code  int
+		want  []float64
+	}{
+		{name: "Test equal", query: `?match=region="eu"`, code: 200, want: []float64{1}},
+		{name: "Test not equal", query: `?match=disk!="sda"`, code: 200, want: []float64{2, 3}},
+		{name: "Test regexp", query: `?match=disk=~"sd.*"`, code: 200, want: []float64{1, 2}},
+		{name: "Test several matchers", query: `?match=disk=~"sd.*"&match=region!=eu`, code: 200, want: []float64{2}},
+		{name: "Test invalid regexp", query: `?match=disk=~"("`, code: 400},
+		{name: "Test invalid matcher", query: `?match=disk`, code: 400},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.URL.RawQuery = test.query[1:]
+
+			w := httptest.NewRecorder()
+			mh.GetMetrics(w, request)
+			require.Equal(t, test.code, w.Code)
+
+			if test.code != http.StatusOK {
+				return
+			}
+
+			var metrics []models.Metrics
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &metrics))
+
+			var values []float64
+			for _, m := range metrics {
+				values = append(values, *m.Value)
+			}
+			assert.Equal(t, test.want, values)
+		})
+	}
+}
+
+func TestUpdateMetricV2Labels(t *testing.T) {
+	storage := new(storage.MemStorage)
+	cfg := config.Default()
+	cfg.MaxSeriesPerMetric = 2
+	mh := NewMetricHandler(storage, cfg)
+
+	value := float64(10)
+	tests := []struct {
+		name   string
+		labels models.Labels
+		code   int
+	}{
+		{name: "Test first series", labels: models.Labels{"disk": "sda"}, code: 200},
+		{name: "Test second series", labels: models.Labels{"disk": "sdb"}, code: 200},
+		{name: "Test existing series", labels: models.Labels{"disk": "sda"}, code: 200},
+		{name: "Test series limit", labels: models.Labels{"disk": "sdc"}, code: 400},
+		{name: "Test invalid label name", labels: models.Labels{"1disk": "sda"}, code: 400},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body, _ := json.Marshal(models.Metrics{ID: "DiskUsed", MType: "gauge", Value: &value, Labels: test.labels})
+			request := httptest.NewRequest(http.MethodPost, "/update/", bytes.NewBuffer(body))
+
+			w := httptest.NewRecorder()
+			mh.UpdateMetricV2(w, request)
+			assert.Equal(t, test.code, w.Code)
+		})
+	}
+
+	assert.Equal(t, 2, storage.CountSeries(context.Background(), "DiskUsed"))
+}
+
+func TestUpdateMetricBatchSeriesLimits(t *testing.T) {
+	gauge := func(name, disk string) models.Metrics {
+		value := float64(1)
+		return models.Metrics{ID: name, MType: "gauge", Value: &value, Labels: models.Labels{"disk": disk}}
+	}
+
+	tests := []struct {
+		name      string
+		perMetric uint64
+		total     uint64
+		batch     []models.Metrics
+		want      int
+		rejected  []int
+	}{
+		{
+			name:      "Test series per metric added by the batch",
+			perMetric: 2,
+			batch:     []models.Metrics{gauge("DiskUsed", "sda"), gauge("DiskUsed", "sdb"), gauge("DiskUsed", "sdc")},
+			want:      2,
+			rejected:  []int{2},
+		},
+		{
+			name:     "Test series in total added by the batch",
+			total:    2,
+			batch:    []models.Metrics{gauge("DiskUsed", "sda"), gauge("DiskFree", "sda"), gauge("DiskIdle", "sda")},
+			want:     2,
+			rejected: []int{2},
+		},
+		{
+			name:      "Test series repeated in the batch",
+			perMetric: 2,
+			total:     2,
+			batch:     []models.Metrics{gauge("DiskUsed", "sda"), gauge("DiskUsed", "sdb"), gauge("DiskUsed", "sda")},
+			want:      2,
+		},
+		{
+			name:     "Test names breaking the policy left out",
+			batch:    []models.Metrics{gauge("Disk-Used", "sda"), gauge("DiskUsed", "sda"), gauge(strings.Repeat("A", 31), "sda")},
+			want:     1,
+			rejected: []int{0, 2},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			storage := new(storage.MemStorage)
+			cfg := config.Default()
+			cfg.MaxSeriesPerMetric = test.perMetric
+			cfg.MaxSeries = test.total
+			mh := NewMetricHandler(storage, cfg)
+
+			body, _ := json.Marshal(test.batch)
+			w := httptest.NewRecorder()
+			mh.UpdateMetricBatch(w, httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewBuffer(body)))
+
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, test.want, storage.CountAllSeries(context.Background()))
+
+			var result struct {
+				Accepted int `json:"accepted"`
+				Rejected []struct {
+					Index int    `json:"index"`
+					Code  string `json:"code"`
+				} `json:"rejected"`
+			}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+			assert.Equal(t, len(test.batch)-len(test.rejected), result.Accepted)
+
+			var rejected []int
+			for _, r := range result.Rejected {
+				assert.NotEmpty(t, r.Code)
+				rejected = append(rejected, r.Index)
+			}
+			assert.Equal(t, test.rejected, rejected)
+		})
+	}
+}
+
+func TestUpdateMetricBatchInvalid(t *testing.T) {
+	one := float64(1)
+	five := int64(5)
+
+	tests := []struct {
+		name     string
+		batch    string
+		wantCode string
+	}{
+		{name: "Test unknown type", batch: `[{"id": "Alloc", "type": "gauge", "value": 1}, {"id": "Sys", "type": "meter", "value": 1}]`, wantCode: "metric_type_unsupported"},
+		{name: "Test gauge without value", batch: `[{"id": "Alloc", "type": "gauge"}]`, wantCode: "metric_value_missing"},
+		{name: "Test counter without delta", batch: `[{"id": "PollCount", "type": "counter"}]`, wantCode: "metric_value_missing"},
+		{name: "Test host too long to store", batch: `[{"id": "PollCount", "type": "counter", "delta": 1, "host": "` + strings.Repeat("h", config.MaxStoredHostLength+1) + `"}]`, wantCode: "host_too_long"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := &storage.MemStorage{Metrics: []models.Metrics{
+				{ID: "Alloc", MType: "gauge", Value: &one},
+				{ID: "PollCount", MType: "counter", Delta: &five},
+			}}
+			mh := NewMetricHandler(s, config.Default())
+
+			w := httptest.NewRecorder()
+			mh.UpdateMetricBatch(w, httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(test.batch)))
+
+			require.Equal(t, http.StatusBadRequest, w.Code)
+
+			var body struct {
+				Error struct {
+					Code string `json:"code"`
+				} `json:"error"`
+			}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+			assert.Equal(t, test.wantCode, body.Error.Code)
+
+			// Nothing of a rejected batch is stored.
+			m, _ := s.GetMetric(context.Background(), "", "PollCount", "counter", nil)
+			assert.Equal(t, five, *m.Delta)
+			assert.Equal(t, 2, s.CountAllSeries(context.Background()))
+		})
+	}
+}
+
+func TestUpdateMetricPolicy(t *testing.T) {
+	storage := new(storage.MemStorage)
+	cfg := config.Default()
+	cfg.MaxSeries = 2
+	cfg.MetricNameDeny = []string{"Debug.*"}
+	mh := NewMetricHandler(storage, cfg)
+
+	tests := []struct {
+		name     string
+		metric   string
+		value    string
+		code     int
+		wantCode string
+	}{
+		{name: "Test first series", metric: "Alloc", value: "1", code: 200},
+		{name: "Test name too long", metric: strings.Repeat("A", 31), value: "1", code: 400, wantCode: "metric_name_too_long"},
+		{name: "Test name not supported", metric: "Heap-Alloc", value: "1", code: 400, wantCode: "metric_name_invalid"},
+		{name: "Test name denied", metric: "DebugAlloc", value: "1", code: 400, wantCode: "metric_name_denied"},
+		{name: "Test NaN", metric: "Alloc", value: "NaN", code: 400, wantCode: "value_not_finite"},
+		{name: "Test infinity", metric: "Alloc", value: "+Inf", code: 400, wantCode: "value_not_finite"},
+		{name: "Test second series", metric: "Sys", value: "1", code: 200},
+		{name: "Test series limit", metric: "Frees", value: "1", code: 400, wantCode: "series_limit_exceeded"},
+		{name: "Test existing series", metric: "Sys", value: "2", code: 200},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/update/gauge/"+test.metric+"/"+test.value, nil)
+			request.SetPathValue("type", "gauge")
+			request.SetPathValue("name", test.metric)
+			request.SetPathValue("value", test.value)
+
+			w := httptest.NewRecorder()
+			mh.UpdateMetric(w, request)
+
+			require.Equal(t, test.code, w.Code)
+			if test.wantCode == "" {
+				return
+			}
+
+			var body struct {
+				Error struct {
+					Code string `json:"code"`
+				} `json:"error"`
+			}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+			assert.Equal(t, test.wantCode, body.Error.Code)
+		})
+	}
+
+	assert.Equal(t, 2, storage.CountAllSeries(context.Background()))
+}
+
+func TestUpdateMetricV2Histogram(t *testing.T) {
+	storage := new(storage.MemStorage)
+	mh := NewMetricHandler(storage, config.Default())
+
+	tests := []struct {
+		name   string
+		metric models.Metrics
+		code   int
+	}{
+		{
+			name:   "Test first report",
+			metric: models.Metrics{ID: "SendLatency", MType: "histogram", Histogram: &models.Histogram{Buckets: []float64{0.1, 1}, Counts: []uint64{1, 2, 0}, Sum: 1.2, Count: 3}},
+			code:   200,
+		},
+		{
+			name:   "Test merge",
+			metric: models.Metrics{ID: "SendLatency", MType: "histogram", Histogram: &models.Histogram{Buckets: []float64{0.1, 1}, Counts: []uint64{0, 0, 1}, Sum: 3, Count: 1}},
+			code:   200,
+		},
+		{
+			name:   "Test buckets mismatch",
+			metric: models.Metrics{ID: "SendLatency", MType: "histogram", Histogram: &models.Histogram{Buckets: []float64{0.5}, Counts: []uint64{1, 0}, Sum: 0.2, Count: 1}},
+			code:   400,
+		},
+		{
+			name:   "Test inconsistent count",
+			metric: models.Metrics{ID: "SendLatency", MType: "histogram", Histogram: &models.Histogram{Buckets: []float64{0.1, 1}, Counts: []uint64{1, 0, 0}, Count: 2}},
+			code:   400,
+		},
+		{
+			name:   "Test histogram missing",
+			metric: models.Metrics{ID: "SendLatency", MType: "histogram"},
+			code:   400,
+		},
+		{
+			name:   "Test summary",
+			metric: models.Metrics{ID: "QueueWait", MType: "summary", Summary: &models.Summary{RelativeAccuracy: 0.01, Positive: map[int]uint64{10: 2}, Sum: 2.2, Count: 2}},
+			code:   200,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body, _ := json.Marshal(test.metric)
+			request := httptest.NewRequest(http.MethodPost, "/update/", bytes.NewBuffer(body))
+
+			w := httptest.NewRecorder()
+			mh.UpdateMetricV2(w, request)
+			assert.Equal(t, test.code, w.Code)
+		})
+	}
+
+	m, found := storage.GetMetric(context.Background(), "", "SendLatency", "histogram", nil)
+	require.True(t, found)
+	assert.Equal(t, []uint64{1, 2, 1}, m.Histogram.Counts)
+	assert.Equal(t, uint64(4), m.Histogram.Count)
+	assert.InDelta(t, 4.2, m.Histogram.Sum, 1e-9)
+}
+
+// failingStorage fails to store batches sent with an idempotency key.
+type failingStorage struct {
+	*storage.MemStorage
+}
+
+func (failingStorage) AddBatchOnce(context.Context, string, []models.Metrics) (bool, error) {
+	return false, errors.New("connection refused")
+}
+
+func TestUpdateMetricBatchStorageFailure(t *testing.T) {
+	s := failingStorage{MemStorage: new(storage.MemStorage)}
+	mh := NewMetricHandler(s, config.Default())
+
+	delta := int64(5)
+	body, _ := json.Marshal([]models.Metrics{{ID: "PollCount", MType: "counter", Delta: &delta}})
+
+	request := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewBuffer(body))
+	request.Header.Set("Idempotency-Key", "batch-1")
+
+	w := newStatusRecorder()
+	mh.UpdateMetricBatch(w, request)
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, 1, w.statuses)
+	assert.Empty(t, w.Header().Get("Idempotent-Replayed"))
+
+	var resp struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "unavailable", resp.Error.Code)
+}
+
+func TestUpdateMetricBatchIdempotency(t *testing.T) {
+	storage := new(storage.MemStorage)
+	storage.IdempotencyWindow = time.Minute
+	mh := NewMetricHandler(storage, config.Default())
+
+	delta := int64(5)
+	body, _ := json.Marshal([]models.Metrics{{ID: "PollCount", MType: "counter", Delta: &delta}})
+
+	tests := []struct {
+		name     string
+		key      string
+		expired  bool
+		code     int
+		replayed string
+		want     int64
+	}{
+		{
+			name: "Test first attempt",
+			key:  "batch-1",
+			code: 200,
+			want: 5,
+		},
+		{
+			name:     "Test retry of applied batch",
+			key:      "batch-1",
+			code:     200,
+			replayed: "true",
+			want:     5,
+		},
+		{
+			name: "Test new batch",
+			key:  "batch-2",
+			code: 200,
+			want: 10,
+		},
+		{
+			name: "Test batch without key",
+			code: 200,
+			want: 15,
+		},
+		{
+			name: "Test key too long",
+			key:  strings.Repeat("k", 129),
+			code: 400,
+			want: 15,
+		},
+		{
+			name:    "Test retry after window",
+			key:     "batch-1",
+			expired: true,
+			code:    200,
+			want:    20,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.expired {
+				storage.IdempotencyWindow = 0
+				defer func() { storage.IdempotencyWindow = time.Minute }()
+			}
+
+			request := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewBuffer(body))
+			if test.key != "" {
+				request.Header.Set("Idempotency-Key", test.key)
+			}
+
+			w := httptest.NewRecorder()
+			mh.UpdateMetricBatch(w, request)
+			assert.Equal(t, test.code, w.Code)
+			assert.Equal(t, test.replayed, w.Header().Get("Idempotent-Replayed"))
+
+			m, _ := storage.GetMetric(context.Background(), "", "PollCount", "counter", nil)
+			assert.Equal(t, test.want, *m.Delta)
+		})
+	}
+}
+
+func TestPingMemStorage(t *testing.T) {
+	mh := NewMetricHandler(new(storage.MemStorage), config.Default())
+
+	w := newStatusRecorder()
+	mh.Ping(w, httptest.NewRequest(http.MethodGet, "/ping", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 1, w.statuses)
+}
@@ -0,0 +1,148 @@
+// Package health serves the liveness and readiness probes of the server.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/lambawebdev/metrics/internal/server/apierror"
+)
+
+// Statuses of a probe and of its checks.
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// Check reports whether one component of the server works.
+type Check func(ctx context.Context) error
+
+// Probe runs a set of named checks. It passes if all of them do, so a probe
+// without checks always passes.
+type Probe struct {
+	timeout time.Duration
+	names   []string
+	checks  map[string]Check
+}
+
+// NewProbe returns a probe whose checks are cancelled after timeout.
+func NewProbe(timeout time.Duration) *Probe {
+	return &Probe{
+		timeout: timeout,
+		checks:  make(map[string]Check),
+	}
+}
+
+// Add adds a check under name, replacing the check of that name if any.
+func (p *Probe) Add(name string, check Check) {
+	if _, ok := p.checks[name]; !ok {
+		p.names = append(p.names, name)
+	}
+	p.checks[name] = check
+}
+
+// Result is the outcome of one check.
+type Result struct {
+	Status   string  `json:"status"`
+	Error    string  `json:"error,omitempty"`
+	Duration float64 `json:"duration_seconds"`
+}
+
+// Report is the outcome of a probe.
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks,omitempty"`
+}
+
+// Run runs the checks concurrently.
+func (p *Probe) Run(ctx context.Context) Report {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	report := Report{Status: StatusOK}
+	if len(p.names) == 0 {
+		return report
+	}
+
+	results := make([]Result, len(p.names))
+
+	var wg sync.WaitGroup
+	for i, name := range p.names {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			results[i] = run(ctx, check)
+		}(i, p.checks[name])
+	}
+	wg.Wait()
+
+	report.Checks = make(map[string]Result, len(p.names))
+	for i, name := range p.names {
+		report.Checks[name] = results[i]
+		if results[i].Status != StatusOK {
+			report.Status = StatusFail
+		}
+	}
+
+	return report
+}
+
+func run(ctx context.Context, check Check) Result {
+	started := time.Now()
+	err := check(ctx)
+
+	result := Result{Status: StatusOK, Duration: time.Since(started).Seconds()}
+	if err != nil {
+		result.Status = StatusFail
+		result.Error = err.Error()
+	}
+
+	return result
+}
+
+// Handler serves the report of the probe, with status 503 if it failed.
+func (p *Probe) Handler() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		report := p.Run(req.Context())
+
+		body, err := json.Marshal(report)
+		if err != nil {
+			apierror.Write(res, http.StatusInternalServerError, apierror.CodeInternal, err.Error(), nil)
+			return
+		}
+
+		status := http.StatusOK
+		if report.Status != StatusOK {
+			status = http.StatusServiceUnavailable
+		}
+
+		res.Header().Set("Content-Type", "application/json")
+		res.Header().Set("Cache-Control", "no-store")
+		res.WriteHeader(status)
+		res.Write(body)
+	}
+}
+
+// MaxAge returns a check that fails once the time returned by last is
+// older than the age returned by maxAge. Until last returns a time, the age
+// is counted from when the check was made.
+func MaxAge(last func() time.Time, maxAge func() time.Duration) Check {
+	since := time.Now()
+
+	return func(context.Context) error {
+		at := last()
+		if at.IsZero() {
+			at = since
+		}
+
+		if age, limit := time.Since(at), maxAge(); age > limit {
+			return fmt.Errorf("last one %s ago, limit %s", age.Round(time.Second), limit)
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,100 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProbeHandler(t *testing.T) {
+	pass := func(context.Context) error { return nil }
+	fail := func(context.Context) error { return errors.New("connection refused") }
+	hang := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	tests := []struct {
+		name   string
+		checks map[string]Check
+		code   int
+		want   Report
+	}{
+		{
+			name: "Test no checks",
+			code: http.StatusOK,
+			want: Report{Status: StatusOK},
+		},
+		{
+			name:   "Test all pass",
+			checks: map[string]Check{"storage": pass, "snapshot": pass},
+			code:   http.StatusOK,
+			want: Report{Status: StatusOK, Checks: map[string]Result{
+				"storage":  {Status: StatusOK},
+				"snapshot": {Status: StatusOK},
+			}},
+		},
+		{
+			name:   "Test one fails",
+			checks: map[string]Check{"storage": fail, "snapshot": pass},
+			code:   http.StatusServiceUnavailable,
+			want: Report{Status: StatusFail, Checks: map[string]Result{
+				"storage":  {Status: StatusFail, Error: "connection refused"},
+				"snapshot": {Status: StatusOK},
+			}},
+		},
+		{
+			name:   "Test timeout",
+			checks: map[string]Check{"storage": hang},
+			code:   http.StatusServiceUnavailable,
+			want: Report{Status: StatusFail, Checks: map[string]Result{
+				"storage": {Status: StatusFail, Error: context.DeadlineExceeded.Error()},
+			}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			probe := NewProbe(10 * time.Millisecond)
+			for name, check := range test.checks {
+				probe.Add(name, check)
+			}
+
+			w := httptest.NewRecorder()
+			probe.Handler()(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			require.Equal(t, test.code, w.Code)
+			assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+			var got Report
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+			for name, result := range got.Checks {
+				result.Duration = 0
+				got.Checks[name] = result
+			}
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestMaxAge(t *testing.T) {
+	var last time.Time
+	limit := time.Minute
+
+	check := MaxAge(func() time.Time { return last }, func() time.Duration { return limit })
+
+	// Nothing happened yet, the age counts from when the check was made.
+	require.NoError(t, check(context.Background()))
+
+	last = time.Now().Add(-30 * time.Second)
+	require.NoError(t, check(context.Background()))
+
+	limit = 10 * time.Second
+	assert.ErrorContains(t, check(context.Background()), "limit 10s")
+}
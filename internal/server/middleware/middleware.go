@@ -2,9 +2,12 @@ package middleware
 
 import (
 	"compress/gzip"
+	"crypto/subtle"
 	"io"
 	"net/http"
 	"strings"
+
+	"github.com/lambawebdev/metrics/internal/server/apierror"
 )
 
 type gzipWriter struct {
@@ -16,8 +19,31 @@ func (w gzipWriter) Write(b []byte) (int, error) {
 	return w.Writer.Write(b)
 }
 
+type gzipReader struct {
+	io.Reader
+	body io.Closer
+}
+
+func (r gzipReader) Close() error {
+	return r.body.Close()
+}
+
+// GzipMiddleware decompresses gzip encoded request bodies and compresses
+// responses for clients that accept gzip.
 func GzipMiddleware(h http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.Header.Get("Content-Encoding"), "gzip") {
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidBody, err.Error(), nil)
+				return
+			}
+			defer zr.Close()
+
+			r.Body = gzipReader{Reader: zr, body: r.Body}
+			r.Header.Del("Content-Encoding")
+		}
+
 		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
 			h.ServeHTTP(w, r)
 			return
@@ -34,3 +60,25 @@ func GzipMiddleware(h http.HandlerFunc) http.HandlerFunc {
 		h.ServeHTTP(gzipWriter{ResponseWriter: w, Writer: gz}, r)
 	})
 }
+
+// AdminAuthMiddleware lets through requests with the admin bearer token.
+// The token is looked up on every request so that a reload can change it.
+// Without a token the admin endpoints do not exist.
+func AdminAuthMiddleware(token func() string, h http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		want := token()
+		if want == "" {
+			apierror.Write(w, http.StatusNotFound, apierror.CodeNotFound, "Not found!", nil)
+			return
+		}
+
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(want)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			apierror.Write(w, http.StatusUnauthorized, apierror.CodeUnauthorized, "Unauthorized!", nil)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,149 @@
+// Package prometheus writes metrics in the Prometheus text exposition format.
+package prometheus
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lambawebdev/metrics/internal/models"
+)
+
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+var invalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9_:]`)
+
+// Write outputs metrics grouped by name, one TYPE line per family. The agent
+// host becomes the "host" label. Series of a name that is reported with
+// several types are written under the first type only.
+func Write(w io.Writer, metrics []models.Metrics) error {
+	sorted := make([]models.Metrics, len(metrics))
+	copy(sorted, metrics)
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return SanitizeName(sorted[i].ID) < SanitizeName(sorted[j].ID)
+	})
+
+	bw := bufio.NewWriter(w)
+
+	familyType := ""
+	family := ""
+	for _, m := range sorted {
+		name := SanitizeName(m.ID)
+
+		if name != family {
+			family = name
+			familyType = m.MType
+			fmt.Fprintf(bw, "# TYPE %s %s\n", name, m.MType)
+		}
+
+		if m.MType != familyType {
+			continue
+		}
+
+		labels := seriesLabels(m)
+
+		switch m.MType {
+		case "gauge":
+			if m.Value != nil {
+				writeSample(bw, name, labels, *m.Value)
+			}
+		case "counter":
+			if m.Delta != nil {
+				writeSample(bw, name, labels, float64(*m.Delta))
+			}
+		case "histogram":
+			if m.Histogram != nil {
+				writeHistogram(bw, name, labels, m.Histogram)
+			}
+		case "summary":
+			if m.Summary != nil {
+				writeSummary(bw, name, labels, m.Summary)
+			}
+		}
+	}
+
+	return bw.Flush()
+}
+
+func SanitizeName(name string) string {
+	name = invalidNameChars.ReplaceAllString(name, "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+
+	return name
+}
+
+func writeHistogram(w io.Writer, name string, labels []string, h *models.Histogram) {
+	var cumulative uint64
+
+	for i, bound := range h.Buckets {
+		cumulative += h.Counts[i]
+		writeSample(w, name+"_bucket", append(labels, label("le", formatFloat(bound))), float64(cumulative))
+	}
+	writeSample(w, name+"_bucket", append(labels, label("le", "+Inf")), float64(h.Count))
+	writeSample(w, name+"_sum", labels, h.Sum)
+	writeSample(w, name+"_count", labels, float64(h.Count))
+}
+
+func writeSummary(w io.Writer, name string, labels []string, s *models.Summary) {
+	for _, q := range models.DefaultQuantiles {
+		writeSample(w, name, append(labels, label("quantile", formatFloat(q))), s.Quantile(q))
+	}
+	writeSample(w, name+"_sum", labels, s.Sum)
+	writeSample(w, name+"_count", labels, float64(s.Count))
+}
+
+func seriesLabels(m models.Metrics) []string {
+	var labels []string
+
+	if m.Host != "" {
+		labels = append(labels, label("host", m.Host))
+	}
+
+	names := make([]string, 0, len(m.Labels))
+	for k := range m.Labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	for _, k := range names {
+		labels = append(labels, label(k, m.Labels[k]))
+	}
+
+	return labels[:len(labels):len(labels)]
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func label(name, value string) string {
+	return name + `="` + labelValueReplacer.Replace(value) + `"`
+}
+
+func writeSample(w io.Writer, name string, labels []string, value float64) {
+	if len(labels) == 0 {
+		fmt.Fprintf(w, "%s %s\n", name, formatFloat(value))
+		return
+	}
+
+	fmt.Fprintf(w, "%s{%s} %s\n", name, strings.Join(labels, ","), formatFloat(value))
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,52 @@
+package prometheus
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/lambawebdev/metrics/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrite(t *testing.T) {
+	value, delta := float64(1.5), int64(7)
+
+	h := models.NewHistogram([]float64{0.1, 1})
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(2)
+
+	s := models.NewSummary(models.DefaultRelativeAccuracy)
+	s.Observe(0)
+
+	metrics := []models.Metrics{
+		{ID: "SendLatency", MType: "histogram", Host: "web-1", Histogram: h},
+		{ID: "PollCount", MType: "counter", Delta: &delta},
+		{ID: "Alloc", MType: "gauge", Value: &value, Labels: models.Labels{"region": "eu", "disk": `sd"a`}},
+		{ID: "Queue.Wait", MType: "summary", Summary: s},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, metrics))
+
+	want := `# TYPE Alloc gauge
+Alloc{disk="sd\"a",region="eu"} 1.5
+# TYPE PollCount counter
+PollCount 7
+# TYPE Queue_Wait summary
+Queue_Wait{quantile="0.5"} 0
+Queue_Wait{quantile="0.9"} 0
+Queue_Wait{quantile="0.95"} 0
+Queue_Wait{quantile="0.99"} 0
+Queue_Wait_sum 0
+Queue_Wait_count 1
+# TYPE SendLatency histogram
+SendLatency_bucket{host="web-1",le="0.1"} 1
+SendLatency_bucket{host="web-1",le="1"} 2
+SendLatency_bucket{host="web-1",le="+Inf"} 3
+SendLatency_sum{host="web-1"} 2.55
+SendLatency_count{host="web-1"} 3
+`
+	assert.Equal(t, want, buf.String())
+}
@@ -0,0 +1,61 @@
+// Package response records what the server answered to the requests it
+// served, for the middlewares that log, trace and count them.
+package response
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Result is what a request was answered with.
+type Result struct {
+	// Status is the status sent, 200 if the handler did not set one.
+	Status int
+	// Size is the size of the body as written by the handler.
+	Size int
+	// Route is the chi route pattern that served the request, empty if no
+	// route matched it.
+	Route string
+}
+
+type recorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (w *recorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	size, err := w.ResponseWriter.Write(b)
+	w.size += size
+	return size, err
+}
+
+// Serve serves req with next and returns what it answered. The route is
+// looked up afterwards, as chi only knows it once routing is done.
+func Serve(next http.Handler, w http.ResponseWriter, req *http.Request) Result {
+	rw := &recorder{ResponseWriter: w}
+	next.ServeHTTP(rw, req)
+
+	result := Result{Status: rw.status, Size: rw.size}
+	if result.Status == 0 {
+		result.Status = http.StatusOK
+	}
+
+	if rctx := chi.RouteContext(req.Context()); rctx != nil {
+		result.Route = rctx.RoutePattern()
+	}
+
+	return result
+}
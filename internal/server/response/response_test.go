@@ -0,0 +1,68 @@
+package response
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServe(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		handler http.HandlerFunc
+		want    Result
+	}{
+		{
+			name: "Test status and size",
+			path: "/value/gauge/Alloc",
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+				w.WriteHeader(http.StatusInternalServerError)
+				io.WriteString(w, "missing")
+			},
+			want: Result{Status: http.StatusNotFound, Size: len("missing"), Route: "/value/{type}/{name}"},
+		},
+		{
+			name: "Test implicit ok",
+			path: "/value/gauge/Alloc",
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				io.WriteString(w, "1")
+			},
+			want: Result{Status: http.StatusOK, Size: 1, Route: "/value/{type}/{name}"},
+		},
+		{
+			name:    "Test nothing written",
+			path:    "/value/gauge/Alloc",
+			handler: func(http.ResponseWriter, *http.Request) {},
+			want:    Result{Status: http.StatusOK, Route: "/value/{type}/{name}"},
+		},
+		{
+			name:    "Test unmatched",
+			path:    "/missing",
+			handler: func(http.ResponseWriter, *http.Request) {},
+			want:    Result{Status: http.StatusNotFound, Size: len("404 page not found\n")},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got Result
+
+			r := chi.NewRouter()
+			r.Use(func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+					got = Serve(next, w, req)
+				})
+			})
+			r.Get("/value/{type}/{name}", test.handler)
+
+			r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, test.path, nil))
+
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
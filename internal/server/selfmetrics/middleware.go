@@ -0,0 +1,30 @@
+package selfmetrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/lambawebdev/metrics/internal/models"
+	"github.com/lambawebdev/metrics/internal/server/response"
+)
+
+// Middleware records the latency and status of every request by the chi
+// route that served it, so that paths with metric names in them do not each
+// become a series. Requests no route matched are recorded under "unmatched".
+func Middleware(r *Registry) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			started := time.Now()
+			result := response.Serve(next, w, req)
+
+			route := result.Route
+			if route == "" {
+				route = "unmatched"
+			}
+
+			r.Observe("HTTPRequestDurationSeconds", models.Labels{"route": route, "method": req.Method}, models.DefaultBuckets, time.Since(started).Seconds())
+			r.Add("HTTPRequests", models.Labels{"route": route, "method": req.Method, "status": strconv.Itoa(result.Status)}, 1)
+		})
+	}
+}
@@ -0,0 +1,151 @@
+// Package selfmetrics keeps the metrics the server records about itself.
+// They are kept apart from the stored metrics, so they are neither limited
+// by nor counted against the policies for what agents send.
+package selfmetrics
+
+import (
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/lambawebdev/metrics/internal/models"
+	"github.com/lambawebdev/metrics/internal/server/prometheus"
+)
+
+// Registry holds counters, gauges and histograms by series. Gauge funcs
+// are read when the metrics are.
+type Registry struct {
+	mu         sync.Mutex
+	metrics    map[string]*models.Metrics
+	gaugeFuncs map[string]gaugeFunc
+}
+
+type gaugeFunc struct {
+	name   string
+	labels models.Labels
+	value  func() float64
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		metrics:    make(map[string]*models.Metrics),
+		gaugeFuncs: make(map[string]gaugeFunc),
+	}
+}
+
+// Default is the registry the server records to.
+var Default = NewRegistry()
+
+// series returns the series of a metric, adding it if it is new.
+func (r *Registry) series(m models.Metrics) *models.Metrics {
+	key := m.Key()
+
+	s, ok := r.metrics[key]
+	if !ok {
+		s = &m
+		r.metrics[key] = s
+	}
+
+	return s
+}
+
+// Add adds delta to a counter.
+func (r *Registry) Add(name string, labels models.Labels, delta int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := r.series(models.Metrics{ID: name, MType: "counter", Labels: labels})
+	if s.Delta == nil {
+		s.Delta = new(int64)
+	}
+	*s.Delta += delta
+}
+
+// Set sets a gauge.
+func (r *Registry) Set(name string, labels models.Labels, value float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := r.series(models.Metrics{ID: name, MType: "gauge", Labels: labels})
+	s.Value = &value
+}
+
+// Observe adds v to a histogram with the given buckets. The buckets of the
+// first observation of a series are kept.
+func (r *Registry) Observe(name string, labels models.Labels, buckets []float64, v float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := r.series(models.Metrics{ID: name, MType: "histogram", Labels: labels})
+	if s.Histogram == nil {
+		s.Histogram = models.NewHistogram(buckets)
+	}
+	s.Histogram.Observe(v)
+}
+
+// GaugeFunc makes a gauge read its value from fn, replacing the previous
+// func of the series.
+func (r *Registry) GaugeFunc(name string, labels models.Labels, fn func() float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m := models.Metrics{ID: name, MType: "gauge", Labels: labels}
+	r.gaugeFuncs[m.Key()] = gaugeFunc{name: name, labels: labels, value: fn}
+}
+
+// Metrics returns a copy of every series, ordered by key.
+func (r *Registry) Metrics() []models.Metrics {
+	r.mu.Lock()
+	funcs := make([]gaugeFunc, 0, len(r.gaugeFuncs))
+	for _, f := range r.gaugeFuncs {
+		funcs = append(funcs, f)
+	}
+	r.mu.Unlock()
+
+	// Gauge funcs may be slow, so they are read without holding the lock.
+	for _, f := range funcs {
+		r.Set(f.name, f.labels, f.value())
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	keys := make([]string, 0, len(r.metrics))
+	for key := range r.metrics {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	metrics := make([]models.Metrics, 0, len(keys))
+	for _, key := range keys {
+		m := *r.metrics[key]
+		if m.Delta != nil {
+			delta := *m.Delta
+			m.Delta = &delta
+		}
+		if m.Value != nil {
+			value := *m.Value
+			m.Value = &value
+		}
+		if m.Histogram != nil {
+			h := *m.Histogram
+			h.Buckets = slices.Clone(h.Buckets)
+			h.Counts = slices.Clone(h.Counts)
+			m.Histogram = &h
+		}
+		metrics = append(metrics, m)
+	}
+
+	return metrics
+}
+
+// Handler serves the metrics of r in the Prometheus text format.
+func Handler(r *Registry) http.HandlerFunc {
+	return func(res http.ResponseWriter, _ *http.Request) {
+		res.Header().Set("Content-Type", prometheus.ContentType)
+		res.WriteHeader(http.StatusOK)
+
+		prometheus.Write(res, r.Metrics())
+	}
+}
@@ -0,0 +1,84 @@
+package selfmetrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lambawebdev/metrics/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+
+	r.Add("MetricsIngested", nil, 2)
+	r.Add("MetricsIngested", nil, 3)
+	r.Set("LastSnapshotTimestampSeconds", nil, 1700000000)
+	r.Observe("SnapshotDurationSeconds", nil, []float64{0.1, 1}, 0.5)
+
+	series := 0
+	r.GaugeFunc("StoredSeries", nil, func() float64 {
+		series++
+		return float64(series)
+	})
+
+	delta := int64(5)
+	timestamp := float64(1700000000)
+	stored := float64(1)
+	want := []models.Metrics{
+		{ID: "MetricsIngested", MType: "counter", Delta: &delta},
+		{ID: "LastSnapshotTimestampSeconds", MType: "gauge", Value: &timestamp},
+		{ID: "StoredSeries", MType: "gauge", Value: &stored},
+		{ID: "SnapshotDurationSeconds", MType: "histogram", Histogram: &models.Histogram{Buckets: []float64{0.1, 1}, Counts: []uint64{0, 1, 0}, Sum: 0.5, Count: 1}},
+	}
+	assert.Equal(t, want, r.Metrics())
+
+	// Gauge funcs are read again every time.
+	assert.Equal(t, float64(2), *r.Metrics()[2].Value)
+}
+
+func TestMiddleware(t *testing.T) {
+	reg := NewRegistry()
+
+	router := chi.NewRouter()
+	router.Use(Middleware(reg))
+	router.Get("/value/{type}/{name}", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	router.Get("/ping", func(w http.ResponseWriter, _ *http.Request) {
+		io.WriteString(w, "ok")
+	})
+
+	for _, path := range []string{"/value/gauge/Alloc", "/value/gauge/Sys", "/ping", "/missing"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	requests := map[string]int64{}
+	latencies := map[string]uint64{}
+	for _, m := range reg.Metrics() {
+		switch m.ID {
+		case "HTTPRequests":
+			requests[m.Labels["route"]+" "+m.Labels["status"]] = *m.Delta
+		case "HTTPRequestDurationSeconds":
+			latencies[m.Labels["route"]] = m.Histogram.Count
+		}
+	}
+
+	assert.Equal(t, map[string]int64{"/value/{type}/{name} 404": 2, "/ping 200": 1, "unmatched 404": 1}, requests)
+	assert.Equal(t, map[string]uint64{"/value/{type}/{name}": 2, "/ping": 1, "unmatched": 1}, latencies)
+}
+
+func TestHandler(t *testing.T) {
+	reg := NewRegistry()
+	reg.Add("StorageRetries", nil, 1)
+
+	res := httptest.NewRecorder()
+	Handler(reg)(res, httptest.NewRequest(http.MethodGet, "/debug/metrics", nil))
+
+	require.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "# TYPE StorageRetries counter\nStorageRetries 1\n", res.Body.String())
+}
@@ -2,14 +2,16 @@ package storage
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
-	"fmt"
 	"io"
 	"os"
+	"sync/atomic"
 	"time"
 
 	"github.com/lambawebdev/metrics/internal/models"
-	"github.com/lambawebdev/metrics/internal/server/config"
+	"github.com/lambawebdev/metrics/internal/server/selfmetrics"
+	"go.uber.org/zap"
 )
 
 type Producer struct {
@@ -69,12 +71,12 @@ func (c *Consumer) ReadEvent() ([]models.Metrics, error) {
 	return event, nil
 }
 
-func GetAllMetrics() ([]models.Metrics, error) {
-	err := CreateDir()
+func GetAllMetrics(dir string) ([]models.Metrics, error) {
+	err := CreateDir(dir)
 	if err != nil {
 		return nil, err
 	}
-	p, err := NewConsumer(config.GetFileStoragePath() + "/metrics.json")
+	p, err := NewConsumer(dir + "/metrics.json")
 
 	if err != nil {
 		return nil, err
@@ -87,8 +89,8 @@ func (c *Consumer) Close() error {
 	return c.file.Close()
 }
 
-func WriteToFile(s MetricStorage) error {
-	p, err := NewProducer(config.GetFileStoragePath() + "/metrics.json")
+func WriteToFile(s MetricStorage, dir string) error {
+	p, err := NewProducer(dir + "/metrics.json")
 
 	if err != nil {
 		return err
@@ -96,7 +98,7 @@ func WriteToFile(s MetricStorage) error {
 
 	defer p.Close()
 
-	m := s.GetAll()
+	m := s.GetAll(context.Background())
 
 	data, err := json.Marshal(m)
 	if err != nil {
@@ -114,21 +116,77 @@ func WriteToFile(s MetricStorage) error {
 	return p.writer.Flush()
 }
 
-func StartToWrite(s MetricStorage, interval uint64) {
-	err := CreateDir()
+// StartToWrite saves the metrics every interval seconds. A new interval sent
+// to reset, with ResetInterval, takes the place of the running one.
+func StartToWrite(s MetricStorage, interval uint64, dir string, reset <-chan uint64, log *zap.Logger) {
+	err := CreateDir(dir)
 
 	if err != nil {
-		fmt.Println(err)
+		log.Error("Snapshot directory not created", zap.String("path", dir), zap.Error(err))
 	}
 
 	storeTicker := time.NewTicker(time.Duration(interval) * time.Second)
-	for range storeTicker.C {
-		WriteToFile(s)
+	for {
+		select {
+		case interval := <-reset:
+			storeTicker.Reset(time.Duration(interval) * time.Second)
+		case <-storeTicker.C:
+			if err := writeSnapshot(s, dir); err != nil {
+				log.Error("Snapshot not written", zap.String("path", dir), zap.Error(err))
+			}
+		}
 	}
 }
 
-func CreateDir() error {
-	err := os.MkdirAll(config.GetFileStoragePath(), 0777)
+// ResetInterval hands interval to the StartToWrite reading reset, a channel
+// with a buffer of one. It never blocks: an interval not taken yet is
+// replaced, as only the latest one matters. Calls must not be concurrent.
+func ResetInterval(reset chan uint64, interval uint64) {
+	select {
+	case reset <- interval:
+		return
+	default:
+	}
+
+	select {
+	case <-reset:
+	default:
+	}
+	reset <- interval
+}
+
+// lastSnapshot is when the latest snapshot was written, in Unix nanoseconds,
+// zero if none was.
+var lastSnapshot atomic.Int64
+
+// LastSnapshot returns when the latest snapshot was written, the zero time
+// if none was.
+func LastSnapshot() time.Time {
+	if ns := lastSnapshot.Load(); ns != 0 {
+		return time.Unix(0, ns)
+	}
+
+	return time.Time{}
+}
+
+// writeSnapshot writes the metrics to the file and records how it went.
+func writeSnapshot(s MetricStorage, dir string) error {
+	started := time.Now()
+
+	if err := WriteToFile(s, dir); err != nil {
+		selfmetrics.Default.Add("SnapshotFailures", nil, 1)
+		return err
+	}
+
+	selfmetrics.Default.Observe("SnapshotDurationSeconds", nil, models.DefaultBuckets, time.Since(started).Seconds())
+	selfmetrics.Default.Set("LastSnapshotTimestampSeconds", nil, float64(time.Now().Unix()))
+	lastSnapshot.Store(time.Now().UnixNano())
+
+	return nil
+}
+
+func CreateDir(dir string) error {
+	err := os.MkdirAll(dir, 0777)
 	if err != nil {
 		return err
 	}
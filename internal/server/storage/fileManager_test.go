@@ -0,0 +1,19 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResetInterval(t *testing.T) {
+	reset := make(chan uint64, 1)
+
+	// Nobody reads the intervals, as when a snapshot is being written.
+	ResetInterval(reset, 10)
+	ResetInterval(reset, 20)
+	ResetInterval(reset, 30)
+
+	assert.Equal(t, uint64(30), <-reset)
+	assert.Empty(t, reset)
+}
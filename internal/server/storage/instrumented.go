@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/lambawebdev/metrics/internal/models"
+	"github.com/lambawebdev/metrics/internal/server/selfmetrics"
+)
+
+// instrumentedStorage records how long the calls to a storage take and how
+// many metrics it takes in.
+type instrumentedStorage struct {
+	storage  MetricStorage
+	registry *selfmetrics.Registry
+}
+
+// Instrument wraps s to record its timings, ingest and series count in r.
+func Instrument(s MetricStorage, r *selfmetrics.Registry) MetricStorage {
+	r.GaugeFunc("StoredSeries", nil, func() float64 {
+		return float64(s.CountAllSeries(context.Background()))
+	})
+
+	return &instrumentedStorage{storage: s, registry: r}
+}
+
+// observe records the duration of an operation that started at started.
+func (i *instrumentedStorage) observe(op string, started time.Time) {
+	i.registry.Observe("StorageOperationDurationSeconds", models.Labels{"operation": op}, models.DefaultBuckets, time.Since(started).Seconds())
+}
+
+func (i *instrumentedStorage) ingested(n int) {
+	i.registry.Add("MetricsIngested", nil, int64(n))
+}
+
+func (i *instrumentedStorage) AddGauge(ctx context.Context, host string, metricName string, labels models.Labels, metricValue float64) {
+	defer i.observe("add_gauge", time.Now())
+	i.storage.AddGauge(ctx, host, metricName, labels, metricValue)
+	i.ingested(1)
+}
+
+func (i *instrumentedStorage) AddCounter(ctx context.Context, host string, metricName string, labels models.Labels, metricValue int64) {
+	defer i.observe("add_counter", time.Now())
+	i.storage.AddCounter(ctx, host, metricName, labels, metricValue)
+	i.ingested(1)
+}
+
+func (i *instrumentedStorage) AddHistogram(ctx context.Context, host string, metricName string, labels models.Labels, histogram models.Histogram) error {
+	defer i.observe("add_histogram", time.Now())
+	if err := i.storage.AddHistogram(ctx, host, metricName, labels, histogram); err != nil {
+		return err
+	}
+	i.ingested(1)
+	return nil
+}
+
+func (i *instrumentedStorage) AddSummary(ctx context.Context, host string, metricName string, labels models.Labels, summary models.Summary) error {
+	defer i.observe("add_summary", time.Now())
+	if err := i.storage.AddSummary(ctx, host, metricName, labels, summary); err != nil {
+		return err
+	}
+	i.ingested(1)
+	return nil
+}
+
+func (i *instrumentedStorage) GetMetric(ctx context.Context, host string, metricName string, metricType string, labels models.Labels) (models.Metrics, bool) {
+	defer i.observe("get_metric", time.Now())
+	return i.storage.GetMetric(ctx, host, metricName, metricType, labels)
+}
+
+func (i *instrumentedStorage) GetAll(ctx context.Context) []models.Metrics {
+	defer i.observe("get_all", time.Now())
+	return i.storage.GetAll(ctx)
+}
+
+func (i *instrumentedStorage) AddBatch(ctx context.Context, metrics []models.Metrics) {
+	defer i.observe("add_batch", time.Now())
+	i.storage.AddBatch(ctx, metrics)
+	i.ingested(len(metrics))
+}
+
+func (i *instrumentedStorage) AddBatchOnce(ctx context.Context, key string, metrics []models.Metrics) (bool, error) {
+	defer i.observe("add_batch", time.Now())
+	applied, err := i.storage.AddBatchOnce(ctx, key, metrics)
+	if applied {
+		i.ingested(len(metrics))
+	}
+	return applied, err
+}
+
+func (i *instrumentedStorage) CountSeries(ctx context.Context, metricName string) int {
+	defer i.observe("count_series", time.Now())
+	return i.storage.CountSeries(ctx, metricName)
+}
+
+func (i *instrumentedStorage) CountAllSeries(ctx context.Context) int {
+	defer i.observe("count_series", time.Now())
+	return i.storage.CountAllSeries(ctx)
+}
+
+func (i *instrumentedStorage) Ping(ctx context.Context) error {
+	return i.storage.Ping(ctx)
+}
+
+func (i *instrumentedStorage) Export(ctx context.Context, fn func(m models.Metrics) error) error {
+	defer i.observe("export", time.Now())
+	return i.storage.Export(ctx, fn)
+}
+
+func (i *instrumentedStorage) Import(ctx context.Context, metrics []models.Metrics, replace bool) error {
+	defer i.observe("import", time.Now())
+	if err := i.storage.Import(ctx, metrics, replace); err != nil {
+		return err
+	}
+
+	i.ingested(len(metrics))
+	return nil
+}
@@ -1,11 +1,36 @@
 package storage
 
-import "github.com/lambawebdev/metrics/internal/models"
+import (
+	"context"
 
+	"github.com/lambawebdev/metrics/internal/models"
+)
+
+// MetricStorage keeps metrics keyed on (host, type, name, labels). An empty
+// host stands for metrics reported without an agent identity, and nil labels
+// are the same series as empty ones.
+//
+// AddBatchOnce applies a batch only if its idempotency key has not been seen
+// within the configured window and reports whether it did. It fails only if
+// the batch could not be stored, which is then safe to send again. Ping reports
+// whether the backend can be reached.
+//
+// Export returns every stored series as of one point in time. Import stores
+// metrics as they are, overwriting the series with the same key; with
+// replace, every other series is dropped too. Either all metrics are
+// imported or none.
 type MetricStorage interface {
-	AddGauge(metricName string, metricValue float64)
-	AddCounter(metricName string, metricValue int64)
-	GetMetric(metricName string, metricType string) (models.Metrics, bool)
-	GetAll() []models.Metrics
-	AddBatch(metrics []models.Metrics)
+	AddGauge(ctx context.Context, host string, metricName string, labels models.Labels, metricValue float64)
+	AddCounter(ctx context.Context, host string, metricName string, labels models.Labels, metricValue int64)
+	AddHistogram(ctx context.Context, host string, metricName string, labels models.Labels, histogram models.Histogram) error
+	AddSummary(ctx context.Context, host string, metricName string, labels models.Labels, summary models.Summary) error
+	GetMetric(ctx context.Context, host string, metricName string, metricType string, labels models.Labels) (models.Metrics, bool)
+	GetAll(ctx context.Context) []models.Metrics
+	AddBatch(ctx context.Context, metrics []models.Metrics)
+	AddBatchOnce(ctx context.Context, key string, metrics []models.Metrics) (applied bool, err error)
+	CountSeries(ctx context.Context, metricName string) int
+	CountAllSeries(ctx context.Context) int
+	Ping(ctx context.Context) error
+	Export(ctx context.Context, fn func(m models.Metrics) error) error
+	Import(ctx context.Context, metrics []models.Metrics, replace bool) error
 }
@@ -1,86 +1,299 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"os"
 	"time"
 
 	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/lambawebdev/metrics/internal/models"
+	"github.com/lambawebdev/metrics/internal/retry"
+	"github.com/lambawebdev/metrics/internal/server/selfmetrics"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
 )
 
 const insertGaugeQuery = `
-            INSERT INTO metrics (name, type, value) VALUES ($1, $2, $3)
-            ON CONFLICT (name)
-            DO UPDATE SET value = $3
+            INSERT INTO metrics (host, name, type, labels, value) VALUES ($1, $2, $3, $4, $5)
+            ON CONFLICT (host, type, name, labels)
+            DO UPDATE SET value = $5
 			`
 
 const insertCounterQuery = `
-            INSERT INTO metrics (name, type, delta) VALUES ($1, $2, $3)
-            ON CONFLICT (name)
-            DO UPDATE SET delta = metrics.delta + $3
+            INSERT INTO metrics (host, name, type, labels, delta) VALUES ($1, $2, $3, $4, $5)
+            ON CONFLICT (host, type, name, labels)
+            DO UPDATE SET delta = metrics.delta + $5
+			`
+
+const insertSeriesQuery = `
+            INSERT INTO metrics (host, name, type, labels) VALUES ($1, $2, $3, $4)
+            ON CONFLICT (host, type, name, labels)
+            DO NOTHING
+			`
+
+const importQuery = `
+            INSERT INTO metrics (host, name, type, labels, delta, value, data) VALUES ($1, $2, $3, $4, $5, $6, $7)
+            ON CONFLICT (host, type, name, labels)
+            DO UPDATE SET delta = $5, value = $6, data = $7
+			`
+
+const deleteExpiredKeysQuery = `
+            DELETE FROM idempotency_keys WHERE applied_at < $1
+			`
+
+const insertKeyQuery = `
+            INSERT INTO idempotency_keys (key) VALUES ($1)
+            ON CONFLICT (key) DO NOTHING
+			`
+
+const selectDataForUpdateQuery = `
+            SELECT data FROM metrics
+            WHERE host = ($1) AND name = ($2) AND type = ($3) AND labels = ($4)
+            FOR UPDATE
+			`
+
+const updateDataQuery = `
+            UPDATE metrics SET data = $5
+            WHERE host = ($1) AND name = ($2) AND type = ($3) AND labels = ($4)
 			`
 
 type PGSQLMetricRepository struct {
-	db *sql.DB
+	db                *sql.DB
+	idempotencyWindow time.Duration
+	retry             retry.Policy
+	log               *zap.Logger
 }
 
-func NewPGSQLMetricRepository(db *sql.DB) *PGSQLMetricRepository {
+func NewPGSQLMetricRepository(db *sql.DB, idempotencyWindow time.Duration, log *zap.Logger) *PGSQLMetricRepository {
 	return &PGSQLMetricRepository{
-		db: db,
+		db:                db,
+		idempotencyWindow: idempotencyWindow,
+		log:               log,
+		retry: retry.Policy{
+			InitialInterval: time.Second,
+			MaxInterval:     5 * time.Second,
+			Multiplier:      2,
+			MaxElapsedTime:  10 * time.Second,
+			Retryable:       retryablePGError,
+			Breaker:         retry.NewBreaker(5, 10*time.Second),
+			OnRetry: func(attempt int, err error, backoff time.Duration) {
+				selfmetrics.Default.Add("StorageRetries", nil, 1)
+				log.Warn("Storage query failed, retrying",
+					zap.Int("attempt", attempt),
+					zap.Duration("backoff", backoff),
+					zap.Error(err),
+				)
+			},
+		},
 	}
 }
 
-func (repo *PGSQLMetricRepository) AddGauge(metricName string, metricValue float64) {
+// retryablePGError reports whether a query may succeed if run again: the
+// connection failed, the transaction lost a serialization conflict or
+// deadlock, or nothing was sent to the server yet.
+func retryablePGError(err error) bool {
 	var pgErr *pgconn.PgError
-	for _, backoff := range backoffSchedule {
-		_, err := repo.db.Exec(insertGaugeQuery, metricName, "gauge", metricValue)
+	if errors.As(err, &pgErr) {
+		return pgerrcode.IsConnectionException(pgErr.Code) || pgerrcode.IsTransactionRollback(pgErr.Code)
+	}
+
+	return pgconn.SafeToRetry(err) || retry.Retryable(err)
+}
+
+// tracer traces the database calls, as children of the request span in ctx.
+var tracer = otel.Tracer("github.com/lambawebdev/metrics/internal/server/storage")
+
+func startSpan(ctx context.Context, op string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "storage."+op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.operation", op),
+		),
+	)
+}
+
+// endSpan ends span, marking it failed if err is not nil.
+func endSpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
 
+// inTx runs fn in a transaction, retrying the whole transaction on failure.
+func (repo *PGSQLMetricRepository) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	return repo.retry.Do(ctx, func() error {
+		tx, err := repo.db.BeginTx(ctx, nil)
 		if err != nil {
-			fmt.Println(err)
+			return err
 		}
 
-		if err == nil || !errors.As(err, &pgErr) {
-			break
+		if err := fn(tx); err != nil {
+			tx.Rollback()
+			return err
 		}
 
-		if pgErr.Code == pgerrcode.ConnectionException {
-			fmt.Fprintf(os.Stderr, "Request error: %+v\n", err)
-			fmt.Fprintf(os.Stderr, "Retrying in %v\n", backoff)
-			time.Sleep(backoff)
-		}
+		return tx.Commit()
+	})
+}
+
+func (repo *PGSQLMetricRepository) AddGauge(ctx context.Context, host string, metricName string, labels models.Labels, metricValue float64) {
+	ctx, span := startSpan(ctx, "AddGauge")
+
+	err := repo.retry.Do(ctx, func() error {
+		_, err := repo.db.ExecContext(ctx, insertGaugeQuery, host, metricName, "gauge", labels, metricValue)
+		return err
+	})
+	endSpan(span, err)
+
+	if err != nil {
+		repo.log.Error("Gauge not stored", zap.String("metric", metricName), zap.String("host", host), zap.Error(err))
 	}
 }
 
-func (repo *PGSQLMetricRepository) AddCounter(metricName string, metricValue int64) {
-	var pgErr *pgconn.PgError
-	for _, backoff := range backoffSchedule {
-		_, err := repo.db.Exec(insertCounterQuery, metricName, "counter", metricValue)
+func (repo *PGSQLMetricRepository) AddCounter(ctx context.Context, host string, metricName string, labels models.Labels, metricValue int64) {
+	ctx, span := startSpan(ctx, "AddCounter")
 
-		if err != nil {
-			fmt.Println(err)
-		}
+	err := repo.retry.Do(ctx, func() error {
+		_, err := repo.db.ExecContext(ctx, insertCounterQuery, host, metricName, "counter", labels, metricValue)
+		return err
+	})
+	endSpan(span, err)
 
-		if err == nil || !errors.As(err, &pgErr) {
-			break
-		}
+	if err != nil {
+		repo.log.Error("Counter not stored", zap.String("metric", metricName), zap.String("host", host), zap.Error(err))
+	}
+}
+
+func (repo *PGSQLMetricRepository) AddHistogram(ctx context.Context, host string, metricName string, labels models.Labels, histogram models.Histogram) error {
+	ctx, span := startSpan(ctx, "AddHistogram")
+
+	err := repo.inTx(ctx, func(tx *sql.Tx) error {
+		return mergeData(ctx, tx, models.Metrics{ID: metricName, MType: "histogram", Host: host, Labels: labels, Histogram: &histogram})
+	})
+	endSpan(span, err)
+
+	return err
+}
+
+func (repo *PGSQLMetricRepository) AddSummary(ctx context.Context, host string, metricName string, labels models.Labels, summary models.Summary) error {
+	ctx, span := startSpan(ctx, "AddSummary")
+
+	err := repo.inTx(ctx, func(tx *sql.Tx) error {
+		return mergeData(ctx, tx, models.Metrics{ID: metricName, MType: "summary", Host: host, Labels: labels, Summary: &summary})
+	})
+	endSpan(span, err)
+
+	return err
+}
+
+// mergeData merges the histogram or summary of m into the stored one. The
+// series row is created first, so concurrent merges serialize on its lock.
+func mergeData(ctx context.Context, tx *sql.Tx, m models.Metrics) error {
+	_, err := tx.ExecContext(ctx, insertSeriesQuery, m.Host, m.ID, m.MType, m.Labels)
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	err = tx.QueryRowContext(ctx, selectDataForUpdateQuery, m.Host, m.ID, m.MType, m.Labels).Scan(&data)
+	if err != nil {
+		return err
+	}
+
+	stored := models.Metrics{MType: m.MType}
+	if err = decodeData(&stored, data); err != nil {
+		return err
+	}
+
+	var merged interface{}
 
-		if pgErr.Code == pgerrcode.ConnectionException {
-			fmt.Fprintf(os.Stderr, "Request error: %+v\n", err)
-			fmt.Fprintf(os.Stderr, "Retrying in %v\n", backoff)
-			time.Sleep(backoff)
+	switch m.MType {
+	case "histogram":
+		if stored.Histogram == nil {
+			stored.Histogram = models.NewHistogram(m.Histogram.Buckets)
 		}
+		err = stored.Histogram.Merge(m.Histogram)
+		merged = stored.Histogram
+	case "summary":
+		if stored.Summary == nil {
+			stored.Summary = models.NewSummary(m.Summary.RelativeAccuracy)
+		}
+		err = stored.Summary.Merge(m.Summary)
+		merged = stored.Summary
+	}
+
+	if err != nil {
+		return err
+	}
+
+	encoded, err := json.Marshal(merged)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, updateDataQuery, m.Host, m.ID, m.MType, m.Labels, string(encoded))
+	return err
+}
+
+// encodeData returns the data column of a metric, nil unless it is a
+// histogram or a summary.
+func encodeData(m models.Metrics) (interface{}, error) {
+	var v interface{}
+
+	switch {
+	case m.MType == "histogram" && m.Histogram != nil:
+		v = m.Histogram
+	case m.MType == "summary" && m.Summary != nil:
+		v = m.Summary
+	default:
+		return nil, nil
+	}
+
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(encoded), nil
+}
+
+// decodeData fills the histogram or summary of a metric from the data column.
+func decodeData(m *models.Metrics, data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	switch m.MType {
+	case "histogram":
+		m.Histogram = new(models.Histogram)
+		return json.Unmarshal(data, m.Histogram)
+	case "summary":
+		m.Summary = new(models.Summary)
+		return json.Unmarshal(data, m.Summary)
 	}
+
+	return nil
 }
 
-func (repo *PGSQLMetricRepository) GetAll() []models.Metrics {
-	rows, err := repo.db.Query("SELECT name, type, delta, value FROM metrics")
+func (repo *PGSQLMetricRepository) GetAll(ctx context.Context) []models.Metrics {
+	ctx, span := startSpan(ctx, "GetAll")
+
+	rows, err := repo.db.QueryContext(ctx, "SELECT host, name, type, labels, delta, value, data FROM metrics")
+	endSpan(span, err)
 
 	if err != nil {
-		fmt.Println(err)
+		repo.log.Error("Metrics not read", zap.Error(err))
+		return nil
 	}
 
 	defer rows.Close()
@@ -89,24 +302,106 @@ func (repo *PGSQLMetricRepository) GetAll() []models.Metrics {
 
 	for rows.Next() {
 		var metric models.Metrics
-		if err := rows.Scan(&metric.ID, &metric.MType, &metric.Delta, &metric.Value); err != nil {
-			fmt.Println(err)
+		var data []byte
+		if err := rows.Scan(&metric.Host, &metric.ID, &metric.MType, &metric.Labels, &metric.Delta, &metric.Value, &data); err != nil {
+			repo.log.Error("Metric not read", zap.Error(err))
+		}
+
+		if err := decodeData(&metric, data); err != nil {
+			repo.log.Error("Metric not decoded", zap.String("metric", metric.ID), zap.String("type", metric.MType), zap.Error(err))
 		}
 
 		metrics = append(metrics, metric)
 	}
 
 	if err := rows.Err(); err != nil {
-		fmt.Println(err)
+		repo.log.Error("Metrics not read", zap.Error(err))
 	}
 
 	return metrics
 }
 
-func (repo *PGSQLMetricRepository) GetMetric(metricName string, metricType string) (models.Metrics, bool) {
+// Export reads the metrics in one statement, so they are as of one point in
+// time, and calls fn with each row as it is scanned. Unlike GetAll it fails
+// on the first row it cannot read rather than leave the row out.
+func (repo *PGSQLMetricRepository) Export(ctx context.Context, fn func(m models.Metrics) error) error {
+	ctx, span := startSpan(ctx, "Export")
+
+	err := repo.export(ctx, fn)
+	endSpan(span, err)
+
+	return err
+}
+
+func (repo *PGSQLMetricRepository) export(ctx context.Context, fn func(m models.Metrics) error) error {
+	rows, err := repo.db.QueryContext(ctx, "SELECT host, name, type, labels, delta, value, data FROM metrics")
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var metric models.Metrics
+		var data []byte
+		if err := rows.Scan(&metric.Host, &metric.ID, &metric.MType, &metric.Labels, &metric.Delta, &metric.Value, &data); err != nil {
+			return err
+		}
+
+		if err := decodeData(&metric, data); err != nil {
+			return fmt.Errorf("metric %s: %w", metric.ID, err)
+		}
+
+		if err := fn(metric); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// Import writes the metrics in one transaction, after deleting every series
+// first if replace is set.
+func (repo *PGSQLMetricRepository) Import(ctx context.Context, metrics []models.Metrics, replace bool) error {
+	ctx, span := startSpan(ctx, "Import")
+
+	err := repo.inTx(ctx, func(tx *sql.Tx) error {
+		if replace {
+			if _, err := tx.ExecContext(ctx, "DELETE FROM metrics"); err != nil {
+				return err
+			}
+		}
+
+		stmt, err := tx.PrepareContext(ctx, importQuery)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		for _, m := range metrics {
+			data, err := encodeData(m)
+			if err != nil {
+				return err
+			}
+
+			if _, err := stmt.ExecContext(ctx, m.Host, m.ID, m.MType, m.Labels, m.Delta, m.Value, data); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	endSpan(span, err)
+
+	return err
+}
+
+func (repo *PGSQLMetricRepository) GetMetric(ctx context.Context, host string, metricName string, metricType string, labels models.Labels) (models.Metrics, bool) {
 	var metric models.Metrics
 	metric.ID = metricName
 	metric.MType = metricType
+	metric.Host = host
+	metric.Labels = labels
 
 	defValue := float64(0)
 	defDelta := int64(0)
@@ -119,85 +414,171 @@ func (repo *PGSQLMetricRepository) GetMetric(metricName string, metricType strin
 		metric.Delta = &defDelta
 	}
 
-	var pgErr *pgconn.PgError
-	for _, backoff := range backoffSchedule {
-		row := repo.db.QueryRow("SELECT name, type, delta, value FROM metrics WHERE type = ($1) AND name = ($2)", metricType, metricName)
+	ctx, span := startSpan(ctx, "GetMetric")
 
-		err := row.Scan(&metric.ID, &metric.MType, &metric.Delta, &metric.Value)
+	err := repo.retry.Do(ctx, func() error {
+		// Without a host, the series without one comes first, then two of
+		// the hosted ones tell whether only one host reported the metric.
+		rows, err := repo.db.QueryContext(ctx, "SELECT host, name, type, labels, delta, value, data FROM metrics WHERE (host = ($1) OR ($1) = '') AND type = ($2) AND name = ($3) AND labels = ($4) ORDER BY host <> ($1) LIMIT 2", host, metricType, metricName, labels)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		var found []models.Metrics
+		for rows.Next() {
+			m := metric
+			var data []byte
+			if err := rows.Scan(&m.Host, &m.ID, &m.MType, &m.Labels, &m.Delta, &m.Value, &data); err != nil {
+				return err
+			}
+			if err := decodeData(&m, data); err != nil {
+				return err
+			}
+			found = append(found, m)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
 
-		if err == nil || !errors.As(err, &pgErr) {
-			break
+		if len(found) == 0 || (found[0].Host != host && len(found) > 1) {
+			return sql.ErrNoRows
 		}
 
-		if pgErr.Code == pgerrcode.ConnectionException {
-			fmt.Fprintf(os.Stderr, "Request error: %+v\n", err)
-			fmt.Fprintf(os.Stderr, "Retrying in %v\n", backoff)
-			time.Sleep(backoff)
+		metric = found[0]
+		return nil
+	})
+	endSpan(span, err)
+
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			repo.log.Error("Metric not read", zap.String("metric", metricName), zap.String("type", metricType), zap.Error(err))
 		}
+		return metric, false
 	}
 
 	return metric, true
 }
 
-func (repo *PGSQLMetricRepository) AddBatch(metrics []models.Metrics) {
-	tx, err := repo.db.Begin()
+func (repo *PGSQLMetricRepository) AddBatch(ctx context.Context, metrics []models.Metrics) {
+	ctx, span := startSpan(ctx, "AddBatch")
+
+	err := repo.inTx(ctx, func(tx *sql.Tx) error {
+		return applyBatch(ctx, tx, metrics)
+	})
+	endSpan(span, err)
+
+	if err != nil {
+		repo.log.Error("Batch not stored", zap.Int("metrics", len(metrics)), zap.Error(err))
+	}
+}
+
+// AddBatchOnce applies metrics unless a batch with the same idempotency key
+// was applied within the idempotency window. The key is recorded in the same
+// transaction as the metrics, so a retried request never applies twice.
+func (repo *PGSQLMetricRepository) AddBatchOnce(ctx context.Context, key string, metrics []models.Metrics) (bool, error) {
+	var applied bool
+
+	ctx, span := startSpan(ctx, "AddBatchOnce")
+
+	err := repo.inTx(ctx, func(tx *sql.Tx) error {
+		applied = false
+
+		if _, err := tx.ExecContext(ctx, deleteExpiredKeysQuery, time.Now().Add(-repo.idempotencyWindow)); err != nil {
+			return err
+		}
+
+		res, err := tx.ExecContext(ctx, insertKeyQuery, key)
+		if err != nil {
+			return err
+		}
+
+		inserted, err := res.RowsAffected()
+		if err != nil || inserted == 0 {
+			return err
+		}
+
+		applied = true
+		return applyBatch(ctx, tx, metrics)
+	})
+	endSpan(span, err)
+
 	if err != nil {
-		fmt.Println(err.Error())
+		repo.log.Error("Batch not stored", zap.String("idempotency_key", key), zap.Int("metrics", len(metrics)), zap.Error(err))
+		return false, err
 	}
 
-	stmtG, err := tx.Prepare(insertGaugeQuery)
+	return applied, nil
+}
+
+func applyBatch(ctx context.Context, tx *sql.Tx, metrics []models.Metrics) error {
+	stmtG, err := tx.PrepareContext(ctx, insertGaugeQuery)
 	if err != nil {
-		tx.Rollback()
-		fmt.Println(err.Error())
+		return err
 	}
 	defer stmtG.Close()
 
-	stmtC, err := tx.Prepare(insertCounterQuery)
+	stmtC, err := tx.PrepareContext(ctx, insertCounterQuery)
 	if err != nil {
-		tx.Rollback()
-		fmt.Println(err.Error())
+		return err
 	}
 	defer stmtC.Close()
 
 	for _, m := range metrics {
 		if m.MType == "gauge" {
-			_, err := stmtG.Exec(m.ID, m.MType, m.Value)
-			if err != nil {
-				tx.Rollback()
-				fmt.Println(err.Error())
+			if _, err := stmtG.ExecContext(ctx, m.Host, m.ID, m.MType, m.Labels, m.Value); err != nil {
+				return err
 			}
 		}
 
 		if m.MType == "counter" {
-			_, err := stmtC.Exec(m.ID, m.MType, m.Delta)
-			if err != nil {
-				tx.Rollback()
-				fmt.Println(err.Error())
+			if _, err := stmtC.ExecContext(ctx, m.Host, m.ID, m.MType, m.Labels, m.Delta); err != nil {
+				return err
 			}
 		}
-	}
 
-	var pgErr *pgconn.PgError
-	for _, backoff := range backoffSchedule {
-		err = tx.Commit()
-		if err != nil {
-			fmt.Println(err.Error())
+		if (m.MType == "histogram" && m.Histogram != nil) || (m.MType == "summary" && m.Summary != nil) {
+			if err := mergeData(ctx, tx, m); err != nil {
+				return err
+			}
 		}
+	}
 
-		if err == nil || !errors.As(err, &pgErr) {
-			break
-		}
+	return nil
+}
 
-		if pgErr.Code == pgerrcode.ConnectionException {
-			fmt.Fprintf(os.Stderr, "Request error: %+v\n", err)
-			fmt.Fprintf(os.Stderr, "Retrying in %v\n", backoff)
-			time.Sleep(backoff)
-		}
+func (repo *PGSQLMetricRepository) CountSeries(ctx context.Context, metricName string) int {
+	var count int
+
+	ctx, span := startSpan(ctx, "CountSeries")
+	err := repo.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM metrics WHERE name = ($1)", metricName).Scan(&count)
+	endSpan(span, err)
+
+	if err != nil {
+		repo.log.Error("Series not counted", zap.String("metric", metricName), zap.Error(err))
+	}
+
+	return count
+}
+
+func (repo *PGSQLMetricRepository) CountAllSeries(ctx context.Context) int {
+	var count int
+
+	ctx, span := startSpan(ctx, "CountAllSeries")
+	err := repo.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM metrics").Scan(&count)
+	endSpan(span, err)
+
+	if err != nil {
+		repo.log.Error("Series not counted", zap.Error(err))
 	}
+
+	return count
 }
 
-var backoffSchedule = []time.Duration{
-	1 * time.Second,
-	3 * time.Second,
-	5 * time.Second,
+func (repo *PGSQLMetricRepository) Ping(ctx context.Context) error {
+	ctx, span := startSpan(ctx, "Ping")
+	err := repo.db.PingContext(ctx)
+	endSpan(span, err)
+
+	return err
 }
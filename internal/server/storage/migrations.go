@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// migrations are applied in order, each one exactly once. The index of a
+// statement plus one is its schema version, so new statements must only
+// ever be appended.
+var migrations = []string{
+	`CREATE TABLE IF NOT EXISTS metrics (
+	    id INT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+		name VARCHAR(30) UNIQUE,
+		type VARCHAR(30),
+		delta BIGINT,
+	    value double precision
+		);
+	`,
+	`ALTER TABLE metrics ADD COLUMN IF NOT EXISTS host VARCHAR(255) NOT NULL DEFAULT ''`,
+	`ALTER TABLE metrics DROP CONSTRAINT IF EXISTS metrics_name_key`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS metrics_host_type_name_idx ON metrics (host, type, name)`,
+	`ALTER TABLE metrics ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}'`,
+	`DROP INDEX IF EXISTS metrics_host_type_name_idx`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS metrics_series_idx ON metrics (host, type, name, labels)`,
+	`ALTER TABLE metrics ADD COLUMN IF NOT EXISTS data JSONB`,
+	`CREATE TABLE IF NOT EXISTS idempotency_keys (
+		key VARCHAR(128) PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)
+	`,
+}
+
+func Migrate(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INT NOT NULL)`)
+	if err != nil {
+		return err
+	}
+
+	version, err := SchemaVersion(context.Background(), db)
+	if err != nil {
+		return err
+	}
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+
+		if _, err = tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if _, err = tx.Exec(`DELETE FROM schema_migrations`); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if _, err = tx.Exec(`INSERT INTO schema_migrations (version) VALUES ($1)`, i+1); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err = tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func SchemaVersion(ctx context.Context, db *sql.DB) (int, error) {
+	var version int
+
+	err := db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+
+	return version, nil
+}
+
+// CheckSchemaVersion reports an error unless every migration is applied.
+func CheckSchemaVersion(ctx context.Context, db *sql.DB) error {
+	version, err := SchemaVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	if version != len(migrations) {
+		return fmt.Errorf("schema version %d, want %d", version, len(migrations))
+	}
+
+	return nil
+}
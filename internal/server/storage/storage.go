@@ -1,64 +1,184 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
-	"fmt"
-	"os"
+	"sync"
+	"time"
 
 	"github.com/lambawebdev/metrics/internal/models"
 	"github.com/lambawebdev/metrics/internal/server/config"
+	"go.uber.org/zap"
 )
 
 type MemStorage struct {
 	Metrics []models.Metrics
+	// IdempotencyWindow is how long the keys of applied batches are kept.
+	IdempotencyWindow time.Duration
+
+	mu sync.Mutex
+	// appliedKeys maps idempotency keys of applied batches to when they
+	// were applied.
+	appliedKeys map[string]time.Time
+	log         *zap.Logger
 }
 
-func GetStorageFactory(db *sql.DB) (MetricStorage, error) {
-	if databaseDsn := os.Getenv("DATABASE_DSN"); databaseDsn != "" {
-		return NewPGSQLMetricRepository(db), nil
+func GetStorageFactory(db *sql.DB, cfg *config.Config, log *zap.Logger) (MetricStorage, error) {
+	if cfg.DatabaseDSN != "" {
+		return NewPGSQLMetricRepository(db, cfg.IdempotencyWindow(), log), nil
 	}
 
-	return InitMemStorage(), nil
+	return InitMemStorage(cfg, log), nil
 }
 
-func (u *MemStorage) AddGauge(metricName string, metricValue float64) {
+// logger returns the logger of the storage, one that discards everything for
+// a storage not made by InitMemStorage.
+func (u *MemStorage) logger() *zap.Logger {
+	if u.log == nil {
+		return zap.NewNop()
+	}
+
+	return u.log
+}
+
+func (u *MemStorage) AddGauge(_ context.Context, host string, metricName string, labels models.Labels, metricValue float64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.addGauge(host, metricName, labels, metricValue)
+}
+
+func (u *MemStorage) addGauge(host string, metricName string, labels models.Labels, metricValue float64) {
 	var metric models.Metrics
 	metric.MType = "gauge"
 	metric.ID = metricName
+	metric.Host = host
+	metric.Labels = labels
 	metric.Value = &metricValue
 
 	for index, m := range u.Metrics {
-		if m.ID == metricName && m.MType == "gauge" {
+		if m.Host == host && m.ID == metricName && m.MType == "gauge" && m.Labels.Equal(labels) {
 			u.Metrics = append(u.Metrics[:index], u.Metrics[index+1:]...)
+			break
 		}
 	}
 
 	u.Metrics = append(u.Metrics, metric)
 }
 
-func (u *MemStorage) AddCounter(metricName string, metricValue int64) {
+func (u *MemStorage) AddCounter(_ context.Context, host string, metricName string, labels models.Labels, metricValue int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.addCounter(host, metricName, labels, metricValue)
+}
+
+func (u *MemStorage) addCounter(host string, metricName string, labels models.Labels, metricValue int64) {
 	var metric models.Metrics
 	metric.MType = "counter"
 	metric.ID = metricName
+	metric.Host = host
+	metric.Labels = labels
 	metric.Delta = &metricValue
 
 	for index, m := range u.Metrics {
-		if m.ID == metricName && m.MType == "counter" {
+		if m.Host == host && m.ID == metricName && m.MType == "counter" && m.Labels.Equal(labels) {
 			v := metricValue + *m.Delta
 			metric.Delta = &v
 
 			u.Metrics = append(u.Metrics[:index], u.Metrics[index+1:]...)
+			break
 		}
 	}
 
 	u.Metrics = append(u.Metrics, metric)
 }
 
-func (u *MemStorage) GetMetric(metricName string, metricType string) (models.Metrics, bool) {
+func (u *MemStorage) AddHistogram(_ context.Context, host string, metricName string, labels models.Labels, histogram models.Histogram) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.addHistogram(host, metricName, labels, histogram)
+}
+
+func (u *MemStorage) addHistogram(host string, metricName string, labels models.Labels, histogram models.Histogram) error {
+	for _, m := range u.Metrics {
+		if m.Host == host && m.ID == metricName && m.MType == "histogram" && m.Labels.Equal(labels) {
+			return m.Histogram.Merge(&histogram)
+		}
+	}
+
+	h := models.NewHistogram(histogram.Buckets)
+	if err := h.Merge(&histogram); err != nil {
+		return err
+	}
+
+	u.Metrics = append(u.Metrics, models.Metrics{
+		ID:        metricName,
+		MType:     "histogram",
+		Host:      host,
+		Labels:    labels,
+		Histogram: h,
+	})
+
+	return nil
+}
+
+func (u *MemStorage) AddSummary(_ context.Context, host string, metricName string, labels models.Labels, summary models.Summary) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.addSummary(host, metricName, labels, summary)
+}
+
+func (u *MemStorage) addSummary(host string, metricName string, labels models.Labels, summary models.Summary) error {
+	for _, m := range u.Metrics {
+		if m.Host == host && m.ID == metricName && m.MType == "summary" && m.Labels.Equal(labels) {
+			return m.Summary.Merge(&summary)
+		}
+	}
+
+	s := models.NewSummary(summary.RelativeAccuracy)
+	if err := s.Merge(&summary); err != nil {
+		return err
+	}
+
+	u.Metrics = append(u.Metrics, models.Metrics{
+		ID:      metricName,
+		MType:   "summary",
+		Host:    host,
+		Labels:  labels,
+		Summary: s,
+	})
+
+	return nil
+}
+
+// GetMetric returns the series of host. Without a host, it is the series
+// reported without one or, if there is none, the series of the only host
+// that reported the metric, as agents used to send no host.
+func (u *MemStorage) GetMetric(_ context.Context, host string, metricName string, metricType string, labels models.Labels) (models.Metrics, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	var hosted []models.Metrics
+
 	for _, metric := range u.Metrics {
-		if metric.ID == metricName {
-			return metric, true
+		if metric.ID != metricName || metric.MType != metricType || !metric.Labels.Equal(labels) {
+			continue
 		}
+
+		if metric.Host == host {
+			return clone(metric), true
+		}
+
+		if host == "" {
+			hosted = append(hosted, metric)
+		}
+	}
+
+	if len(hosted) == 1 {
+		return clone(hosted[0]), true
 	}
 
 	var m models.Metrics
@@ -68,6 +188,8 @@ func (u *MemStorage) GetMetric(metricName string, metricType string) (models.Met
 
 	m.ID = metricName
 	m.MType = metricType
+	m.Host = host
+	m.Labels = labels
 
 	if metricType == "gauge" {
 		m.Value = &defValue
@@ -80,25 +202,172 @@ func (u *MemStorage) GetMetric(metricName string, metricType string) (models.Met
 	return m, false
 }
 
-func (u *MemStorage) GetAll() []models.Metrics {
-	return u.Metrics
+func (u *MemStorage) GetAll(_ context.Context) []models.Metrics {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	metrics := make([]models.Metrics, len(u.Metrics))
+	for i, m := range u.Metrics {
+		metrics[i] = clone(m)
+	}
+
+	return metrics
+}
+
+// clone copies the histogram or summary of m, which are merged into in
+// place, so that m can be read without holding the lock.
+func clone(m models.Metrics) models.Metrics {
+	if m.Histogram != nil {
+		m.Histogram = m.Histogram.Clone()
+	}
+
+	if m.Summary != nil {
+		m.Summary = m.Summary.Clone()
+	}
+
+	return m
+}
+
+func (u *MemStorage) AddBatch(_ context.Context, metrics []models.Metrics) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.addBatch(metrics)
+}
+
+// AddBatchOnce applies metrics unless a batch with the same idempotency key
+// was applied within the idempotency window. It reports whether it did.
+func (u *MemStorage) AddBatchOnce(_ context.Context, key string, metrics []models.Metrics) (bool, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	now := time.Now()
+	window := u.IdempotencyWindow
+
+	if u.appliedKeys == nil {
+		u.appliedKeys = make(map[string]time.Time)
+	}
+
+	for k, appliedAt := range u.appliedKeys {
+		if now.Sub(appliedAt) >= window {
+			delete(u.appliedKeys, k)
+		}
+	}
+
+	if _, applied := u.appliedKeys[key]; applied {
+		return false, nil
+	}
+
+	u.addBatch(metrics)
+	u.appliedKeys[key] = now
+
+	return true, nil
+}
+
+func (u *MemStorage) addBatch(metrics []models.Metrics) {
+	for _, m := range metrics {
+		if m.MType == "gauge" && m.Value != nil {
+			u.addGauge(m.Host, m.ID, m.Labels, *m.Value)
+		}
+
+		if m.MType == "counter" && m.Delta != nil {
+			u.addCounter(m.Host, m.ID, m.Labels, *m.Delta)
+		}
+
+		if m.MType == "histogram" && m.Histogram != nil {
+			if err := u.addHistogram(m.Host, m.ID, m.Labels, *m.Histogram); err != nil {
+				u.logger().Error("Histogram not merged", zap.String("metric", m.ID), zap.String("host", m.Host), zap.Error(err))
+			}
+		}
+
+		if m.MType == "summary" && m.Summary != nil {
+			if err := u.addSummary(m.Host, m.ID, m.Labels, *m.Summary); err != nil {
+				u.logger().Error("Summary not merged", zap.String("metric", m.ID), zap.String("host", m.Host), zap.Error(err))
+			}
+		}
+	}
+}
+
+func (u *MemStorage) CountSeries(_ context.Context, metricName string) int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	count := 0
+
+	for _, m := range u.Metrics {
+		if m.ID == metricName {
+			count++
+		}
+	}
+
+	return count
 }
 
-func (u *MemStorage) AddBatch(metrics []models.Metrics) {
+func (u *MemStorage) CountAllSeries(_ context.Context) int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return len(u.Metrics)
+}
+
+// Ping never fails, the metrics are in memory.
+func (u *MemStorage) Ping(_ context.Context) error {
+	return nil
+}
+
+// Export calls fn with copies of the metrics, like GetAll returns, until fn
+// fails.
+func (u *MemStorage) Export(ctx context.Context, fn func(m models.Metrics) error) error {
+	for _, m := range u.GetAll(ctx) {
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (u *MemStorage) Import(_ context.Context, metrics []models.Metrics, replace bool) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	var stored []models.Metrics
+	if !replace {
+		stored = append(stored, u.Metrics...)
+	}
+
+	index := make(map[string]int, len(stored))
+	for i, m := range stored {
+		index[m.Key()] = i
+	}
+
+	for _, m := range metrics {
+		if i, ok := index[m.Key()]; ok {
+			stored[i] = m
+			continue
+		}
+
+		index[m.Key()] = len(stored)
+		stored = append(stored, m)
+	}
 
+	u.Metrics = stored
+	return nil
 }
 
-func InitMemStorage() *MemStorage {
+func InitMemStorage(cfg *config.Config, log *zap.Logger) *MemStorage {
 	var m []models.Metrics
 	Storage := &MemStorage{
-		Metrics: m,
+		Metrics:           m,
+		IdempotencyWindow: cfg.IdempotencyWindow(),
+		log:               log,
 	}
 
-	if config.GetRestoreMetrics() {
-		m, err := GetAllMetrics()
+	if cfg.Restore {
+		m, err := GetAllMetrics(cfg.FileStoragePath)
 
 		if err != nil {
-			fmt.Println(err)
+			log.Error("Metrics not restored", zap.String("path", cfg.FileStoragePath), zap.Error(err))
 		}
 
 		Storage.Metrics = m
@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"github.com/lambawebdev/metrics/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMemStorageConcurrentMergeAndRead is meant for -race: the metrics read
+// must not share the histograms and summaries merged into.
+func TestMemStorageConcurrentMergeAndRead(t *testing.T) {
+	ctx := context.Background()
+	s := new(MemStorage)
+
+	h := models.NewHistogram([]float64{0.1, 1})
+	h.Observe(0.5)
+	sum := models.NewSummary(models.DefaultRelativeAccuracy)
+	sum.Observe(0.5)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			require.NoError(t, s.AddHistogram(ctx, "", "SendLatency", nil, *h))
+			require.NoError(t, s.AddSummary(ctx, "", "SendSize", nil, *sum))
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			_, err := json.Marshal(s.GetAll(ctx))
+			require.NoError(t, err)
+
+			m, _ := s.GetMetric(ctx, "", "SendLatency", "histogram", nil)
+			_, err = json.Marshal(m)
+			require.NoError(t, err)
+		}
+	}()
+
+	wg.Wait()
+
+	m, found := s.GetMetric(ctx, "", "SendLatency", "histogram", nil)
+	require.True(t, found)
+	assert.Equal(t, uint64(200), m.Histogram.Count)
+
+	// Changing a metric read does not change the stored one.
+	m.Histogram.Observe(0.5)
+	stored, _ := s.GetMetric(ctx, "", "SendLatency", "histogram", nil)
+	assert.Equal(t, uint64(200), stored.Histogram.Count)
+}
+
+func TestMemStorageGetMetricWithoutHost(t *testing.T) {
+	one, two := float64(1), float64(2)
+
+	tests := []struct {
+		name      string
+		stored    []models.Metrics
+		wantFound bool
+		wantHost  string
+	}{
+		{
+			name:      "Test only host",
+			stored:    []models.Metrics{{ID: "Alloc", MType: "gauge", Host: "web-1", Value: &one}},
+			wantFound: true,
+			wantHost:  "web-1",
+		},
+		{
+			name: "Test series without host first",
+			stored: []models.Metrics{
+				{ID: "Alloc", MType: "gauge", Host: "web-1", Value: &one},
+				{ID: "Alloc", MType: "gauge", Value: &two},
+			},
+			wantFound: true,
+		},
+		{
+			name: "Test several hosts",
+			stored: []models.Metrics{
+				{ID: "Alloc", MType: "gauge", Host: "web-1", Value: &one},
+				{ID: "Alloc", MType: "gauge", Host: "web-2", Value: &two},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := &MemStorage{Metrics: test.stored}
+
+			m, found := s.GetMetric(context.Background(), "", "Alloc", "gauge", nil)
+
+			require.Equal(t, test.wantFound, found)
+			if found {
+				assert.Equal(t, test.wantHost, m.Host)
+			}
+
+			_, found = s.GetMetric(context.Background(), "web-3", "Alloc", "gauge", nil)
+			assert.False(t, found)
+		})
+	}
+}
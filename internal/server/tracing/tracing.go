@@ -0,0 +1,143 @@
+// Package tracing ties the requests of the server to the agent sends that
+// caused them. The agent sends a W3C traceparent and an X-Request-ID header
+// with every request. The server continues that trace in a span per request,
+// the storage adds spans for its database calls, and the IDs are logged with
+// every request. Traces are exported over OTLP/HTTP when an endpoint is set.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/lambawebdev/metrics/internal/server/response"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+const maxRequestIDLength = 128
+
+var tracer = otel.Tracer("github.com/lambawebdev/metrics/internal/server/tracing")
+
+type requestIDKey struct{}
+
+// Setup makes the server read trace context from requests and, if endpoint
+// is set, export its spans to the OTLP/HTTP collector at that URL. The
+// returned func flushes the spans not exported yet.
+func Setup(ctx context.Context, endpoint string) (func(ctx context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	if endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attribute.String("service.name", "metrics-server")))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// Middleware serves every request in a span continuing the trace of its
+// traceparent header, and with the request ID of its X-Request-ID header. A
+// request without a usable ID gets a new one. The ID is sent back in the
+// response.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+		requestID := r.Header.Get(RequestIDHeader)
+		if requestID == "" || len(requestID) > maxRequestIDLength {
+			requestID = newRequestID()
+		}
+		ctx = context.WithValue(ctx, requestIDKey{}, requestID)
+
+		ctx, span := tracer.Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+				attribute.String("request.id", requestID),
+			),
+		)
+		defer span.End()
+
+		w.Header().Set(RequestIDHeader, requestID)
+
+		result := response.Serve(next, w, r.WithContext(ctx))
+
+		if result.Route != "" {
+			span.SetName(r.Method + " " + result.Route)
+			span.SetAttributes(attribute.String("http.route", result.Route))
+		}
+
+		span.SetAttributes(attribute.Int("http.response.status_code", result.Status))
+		if result.Status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(result.Status))
+		}
+	})
+}
+
+// randRead fills request IDs, replaced in tests.
+var randRead = rand.Read
+
+// requestSeq numbers the request IDs made without randomness.
+var requestSeq atomic.Uint64
+
+// newRequestID returns a random ID. Should reading randomness fail, the ID
+// is made of the time and a sequence number instead, so it is still unique.
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := randRead(b); err != nil {
+		binary.BigEndian.PutUint64(b, uint64(time.Now().UnixNano()))
+		binary.BigEndian.PutUint64(b[8:], requestSeq.Add(1))
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// RequestID returns the ID of the request ctx belongs to.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// LogFields returns the request and trace IDs of ctx to log with.
+func LogFields(ctx context.Context) []zap.Field {
+	var fields []zap.Field
+
+	if id := RequestID(ctx); id != "" {
+		fields = append(fields, zap.String("request_id", id))
+	}
+
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		fields = append(fields, zap.String("trace_id", sc.TraceID().String()), zap.String("span_id", sc.SpanID().String()))
+	}
+
+	return fields
+}
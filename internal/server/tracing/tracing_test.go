@@ -0,0 +1,120 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.uber.org/zap"
+)
+
+func TestMiddleware(t *testing.T) {
+	previousProvider, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+	})
+
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+
+	_, err := Setup(context.Background(), "")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		requestID   string
+		traceparent string
+		wantTraceID string
+		wantParent  string
+	}{
+		{
+			name:        "Test agent trace continued",
+			requestID:   "5f0c6f0e2d1a4b3c",
+			traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			wantTraceID: "4bf92f3577b34da6a3ce929d0e0e4736",
+			wantParent:  "00f067aa0ba902b7",
+		},
+		{
+			name: "Test new trace",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			exporter.Reset()
+
+			var fields []zap.Field
+			var requestID string
+
+			r := chi.NewRouter()
+			r.Use(Middleware)
+			r.Post("/update/{type}/{name}/{value}", func(w http.ResponseWriter, r *http.Request) {
+				fields = LogFields(r.Context())
+				requestID = RequestID(r.Context())
+				w.WriteHeader(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/update/gauge/Alloc/1", nil)
+			if test.requestID != "" {
+				req.Header.Set(RequestIDHeader, test.requestID)
+			}
+			if test.traceparent != "" {
+				req.Header.Set("traceparent", test.traceparent)
+			}
+			res := httptest.NewRecorder()
+
+			r.ServeHTTP(res, req)
+
+			if test.requestID != "" {
+				assert.Equal(t, test.requestID, requestID)
+			} else {
+				assert.Len(t, requestID, 32)
+			}
+			assert.Equal(t, requestID, res.Header().Get(RequestIDHeader))
+
+			spans := exporter.GetSpans()
+			require.Len(t, spans, 1)
+			span := spans[0]
+
+			assert.Equal(t, "POST /update/{type}/{name}/{value}", span.Name)
+			assert.Contains(t, span.Attributes, attribute.String("http.route", "/update/{type}/{name}/{value}"))
+			assert.Contains(t, span.Attributes, attribute.Int("http.response.status_code", http.StatusOK))
+
+			if test.wantTraceID != "" {
+				assert.Equal(t, test.wantTraceID, span.SpanContext.TraceID().String())
+				assert.Equal(t, test.wantParent, span.Parent.SpanID().String())
+			} else {
+				assert.False(t, span.Parent.IsValid())
+			}
+
+			assert.Equal(t, []zap.Field{
+				zap.String("request_id", requestID),
+				zap.String("trace_id", span.SpanContext.TraceID().String()),
+				zap.String("span_id", span.SpanContext.SpanID().String()),
+			}, fields)
+		})
+	}
+}
+
+func TestNewRequestIDWithoutRandomness(t *testing.T) {
+	previous := randRead
+	t.Cleanup(func() { randRead = previous })
+
+	randRead = func([]byte) (int, error) {
+		return 0, errors.New("no entropy")
+	}
+
+	first, second := newRequestID(), newRequestID()
+
+	assert.Len(t, first, 32)
+	assert.NotEqual(t, first, second)
+}
@@ -0,0 +1,148 @@
+package validators
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+)
+
+// Violation codes of the metric policies.
+const (
+	CodeNameEmpty   = "metric_name_empty"
+	CodeNameTooLong = "metric_name_too_long"
+	CodeHostTooLong = "host_too_long"
+	CodeNameInvalid = "metric_name_invalid"
+	CodeNameDenied  = "metric_name_denied"
+	CodeTooMany     = "series_limit_exceeded"
+	CodeNotFinite   = "value_not_finite"
+)
+
+// Violation is a metric the server refuses to store, with a stable code for
+// clients to act on.
+type Violation struct {
+	Code    string
+	Message string
+	Details map[string]interface{}
+}
+
+func (v *Violation) Error() string {
+	return v.Message
+}
+
+// NamePolicy decides which metric names the server stores. A name has to
+// match Pattern, fit in MaxLength, match one of Allow if there are any and
+// none of Deny. Allow and Deny patterns match whole names.
+type NamePolicy struct {
+	Pattern   *regexp.Regexp
+	MaxLength int
+	Allow     []*regexp.Regexp
+	Deny      []*regexp.Regexp
+}
+
+// NewNamePolicy compiles the patterns of a name policy.
+func NewNamePolicy(pattern string, maxLength int, allow, deny []string) (*NamePolicy, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &NamePolicy{Pattern: re, MaxLength: maxLength}
+
+	if p.Allow, err = compileWhole(allow); err != nil {
+		return nil, err
+	}
+
+	if p.Deny, err = compileWhole(deny); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func compileWhole(patterns []string) ([]*regexp.Regexp, error) {
+	var compiled []*regexp.Regexp
+
+	for _, pattern := range patterns {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, re)
+	}
+
+	return compiled, nil
+}
+
+// ValidateNameLength returns why name is empty or longer than maxLength, or
+// nil if it is neither. A maxLength of zero does not limit the length.
+func ValidateNameLength(name string, maxLength int) *Violation {
+	if name == "" {
+		return &Violation{Code: CodeNameEmpty, Message: "Metric name is empty!"}
+	}
+
+	if maxLength > 0 && len(name) > maxLength {
+		details := map[string]interface{}{"name": name[:maxLength] + "...", "max_length": maxLength}
+		return &Violation{Code: CodeNameTooLong, Message: fmt.Sprintf("Metric name is longer than %d!", maxLength), Details: details}
+	}
+
+	return nil
+}
+
+// ValidateHost returns why host is longer than maxLength, or nil if it is
+// not.
+func ValidateHost(host string, maxLength int) *Violation {
+	if len(host) > maxLength {
+		details := map[string]interface{}{"host": host[:maxLength] + "...", "max_length": maxLength}
+		return &Violation{Code: CodeHostTooLong, Message: fmt.Sprintf("Host is longer than %d!", maxLength), Details: details}
+	}
+
+	return nil
+}
+
+// Check returns why name breaks the policy, or nil if it does not.
+func (p *NamePolicy) Check(name string) *Violation {
+	if v := ValidateNameLength(name, p.MaxLength); v != nil {
+		return v
+	}
+
+	details := map[string]interface{}{"name": name}
+
+	if !p.Pattern.MatchString(name) {
+		details["pattern"] = p.Pattern.String()
+		return &Violation{Code: CodeNameInvalid, Message: "Metric name is not supported!", Details: details}
+	}
+
+	if len(p.Allow) > 0 && !matchesAny(p.Allow, name) {
+		return &Violation{Code: CodeNameDenied, Message: "Metric name is not allowed!", Details: details}
+	}
+
+	if matchesAny(p.Deny, name) {
+		return &Violation{Code: CodeNameDenied, Message: "Metric name is not allowed!", Details: details}
+	}
+
+	return nil
+}
+
+func matchesAny(patterns []*regexp.Regexp, name string) bool {
+	for _, re := range patterns {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CheckGaugeValue rejects NaN and infinite gauge values, which cannot be
+// stored as JSON or compared.
+func CheckGaugeValue(name string, value float64) *Violation {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return &Violation{
+			Code:    CodeNotFinite,
+			Message: "Metric value is not finite!",
+			Details: map[string]interface{}{"name": name, "value": fmt.Sprint(value)},
+		}
+	}
+
+	return nil
+}
@@ -1,13 +1,21 @@
 package validators
 
 import (
-	"net/http"
+	"fmt"
+	"regexp"
 	"slices"
 	"strconv"
+
+	"github.com/lambawebdev/metrics/internal/models"
+	"github.com/lambawebdev/metrics/internal/server/apierror"
 )
 
+const maxLabelValueLength = 128
+
+var labelNameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
 func allowedMetricTypes() []string {
-	return []string{"gauge", "counter"}
+	return []string{"gauge", "counter", "histogram", "summary"}
 }
 
 func TypesMetrics() map[string][]string {
@@ -25,36 +33,103 @@ func TypesMetrics() map[string][]string {
 	}
 }
 
-func ValidateMetricType(metricType string, res http.ResponseWriter) bool {
+func ValidateMetricType(metricType string) *Violation {
 	if !slices.Contains(allowedMetricTypes(), metricType) {
-		http.Error(res, "Metric type is not supported!", http.StatusBadRequest)
-		return false
+		return &Violation{
+			Code:    apierror.CodeMetricTypeUnsupported,
+			Message: "Metric type is not supported!",
+			Details: map[string]interface{}{"type": metricType, "supported": allowedMetricTypes()},
+		}
 	}
-	return true
+	return nil
 }
 
-func ValidateMetricName(metricType string, metricName string, res http.ResponseWriter) bool {
+func ValidateMetricName(metricType string, metricName string) *Violation {
 	if !slices.Contains(TypesMetrics()[metricType], metricName) {
-		http.Error(res, "Metric not exists!", http.StatusNotFound)
-		return false
+		return &Violation{
+			Code:    apierror.CodeMetricNotFound,
+			Message: "Metric not exists!",
+			Details: map[string]interface{}{"type": metricType, "name": metricName},
+		}
 	}
-	return true
+	return nil
 }
 
-func ValidateMetricValue(metricType string, metricValue string, res http.ResponseWriter) {
-	if metricType == "gauge" {
-		_, err := strconv.ParseFloat(metricValue, 64)
-		if err != nil {
-			http.Error(res, "Metric value not supported!", http.StatusBadRequest)
-			return
+// ValidateMetricValue checks a value given as text, which only gauges and
+// counters can have.
+func ValidateMetricValue(metricType string, metricValue string) *Violation {
+	invalid := &Violation{
+		Code:    apierror.CodeMetricValueInvalid,
+		Message: "Metric value not supported!",
+		Details: map[string]interface{}{"type": metricType, "value": metricValue},
+	}
+
+	switch metricType {
+	case "gauge":
+		if _, err := strconv.ParseFloat(metricValue, 64); err != nil {
+			return invalid
 		}
+	case "counter":
+		if _, err := strconv.ParseInt(metricValue, 10, 64); err != nil {
+			return invalid
+		}
+	default:
+		return invalid
 	}
 
-	if metricType == "counter" {
-		_, err := strconv.ParseInt(metricValue, 10, 64)
-		if err != nil {
-			http.Error(res, "Metric value not supported!", http.StatusBadRequest)
-			return
+	return nil
+}
+
+func ValidateLabels(labels models.Labels, maxLabels uint64) *Violation {
+	if maxLabels != 0 && uint64(len(labels)) > maxLabels {
+		return &Violation{
+			Code:    apierror.CodeLabelsInvalid,
+			Message: fmt.Sprintf("Too many labels, max %d!", maxLabels),
+			Details: map[string]interface{}{"max_labels": maxLabels},
 		}
 	}
+
+	for name, value := range labels {
+		if !labelNameRegexp.MatchString(name) {
+			return &Violation{
+				Code:    apierror.CodeLabelsInvalid,
+				Message: fmt.Sprintf("Label name %q is not supported!", name),
+				Details: map[string]interface{}{"label": name},
+			}
+		}
+
+		if len(value) > maxLabelValueLength {
+			return &Violation{
+				Code:    apierror.CodeLabelsInvalid,
+				Message: fmt.Sprintf("Label %q value is too long!", name),
+				Details: map[string]interface{}{"label": name, "max_length": maxLabelValueLength},
+			}
+		}
+	}
+
+	return nil
+}
+
+// ValidateDistribution checks the histogram or summary payload of a metric.
+func ValidateDistribution(m models.Metrics) *Violation {
+	var err error
+
+	switch m.MType {
+	case "histogram":
+		if m.Histogram == nil {
+			return &Violation{Code: apierror.CodeMetricValueMissing, Message: "histogram have to be present", Details: map[string]interface{}{"name": m.ID}}
+		}
+		err = m.Histogram.Validate()
+	case "summary":
+		if m.Summary == nil {
+			return &Violation{Code: apierror.CodeMetricValueMissing, Message: "summary have to be present", Details: map[string]interface{}{"name": m.ID}}
+		}
+		err = m.Summary.Validate()
+	}
+
+	if err != nil {
+		return &Violation{Code: apierror.CodeDistributionInvalid, Message: err.Error(), Details: map[string]interface{}{"name": m.ID}}
+	}
+
+	return nil
 }